@@ -17,9 +17,11 @@ import (
 	"load-balancer/internal/config"
 	"load-balancer/internal/health"
 	"load-balancer/internal/metrics"
+	"load-balancer/internal/outlier"
 	"load-balancer/internal/proxy"
-	"load-balancer/internal/retry"
 	"load-balancer/internal/session"
+	"load-balancer/internal/tcpproxy"
+	"load-balancer/internal/udpproxy"
 	"load-balancer/pkg/tls"
 )
 
@@ -38,55 +40,112 @@ func main() {
 	m := metrics.New()
 
 	// Initialize balancer with configured algorithm
-	b := balancer.New(cfg.Algorithm)
+	b, err := balancer.NewWithConfig(cfg.GetBalancerConfig())
+	if err != nil {
+		log.Fatalf("Failed to create balancer: %v", err)
+	}
 
 	// Initialize proxy
 	p := proxy.New(m)
-	p.SetBalancer(b)
 
 	// Initialize session manager if sticky sessions are enabled
 	if cfg.StickySession.Enabled {
 		sessionManager := session.NewManager(cfg.GetSessionConfig())
+		b = balancer.NewSticky(b, sessionManager)
 		p.SetSessionManager(sessionManager)
 		defer sessionManager.Stop()
 	}
+	p.SetBalancer(b)
+
+	// Configure per-request retries across backends
+	retryConfig, err := cfg.GetRetryConfig()
+	if err != nil {
+		log.Fatalf("Failed to get retry config: %v", err)
+	}
+	p.SetRetryConfig(retryConfig)
+	p.SetMaxBodyBuffer(cfg.Retry.MaxBodyBuffer)
+	p.SetMaxBodySpill(cfg.Retry.MaxBodySpill)
+	if err := p.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		log.Fatalf("Failed to set trusted proxies: %v", err)
+	}
+	p.SetDefaultRoute(cfg.GetDefaultRoute())
+	p.SetRoutes(cfg.GetRoutes())
+
+	// Initialize outlier detection if enabled
+	var outliers *outlier.Detector
+	if cfg.OutlierDetection.Enabled {
+		outliers = outlier.New(cfg.GetOutlierConfig())
+		outliers.SetMetrics(m)
+		p.SetOutlierDetector(outliers)
+		defer outliers.Stop()
+	}
 
 	// Initialize health check scheduler
 	scheduler := health.NewScheduler(time.Duration(cfg.HealthCheck.Interval))
+	scheduler.SetThresholds(cfg.HealthCheck.Rise, cfg.HealthCheck.Fall)
+	scheduler.SetInitialState(cfg.HealthCheck.InitialState != "down")
+	scheduler.SetMetrics(m)
+
+	breakerConfig, err := cfg.GetCircuitBreakerConfig()
+	if err != nil {
+		log.Fatalf("Failed to get circuit breaker config: %v", err)
+	}
 
 	// Add backends from configuration
 	for _, backendCfg := range cfg.Backends {
-		// Create backend with retry settings
+		// Create backend
+		protocol := backend.Protocol(backendCfg.Protocol)
 		backend := backend.New(backendCfg.ID, backendCfg.URL, backendCfg.Weight)
-		backend.SetRetryConfig(&retry.Config{
-			MaxRetries:      cfg.Retry.MaxRetries,
-			InitialInterval: time.Duration(cfg.Retry.InitialInterval),
-			MaxInterval:     time.Duration(cfg.Retry.MaxInterval),
-			Multiplier:      cfg.Retry.Multiplier,
-			Randomization:   cfg.Retry.Randomization,
-		})
+		backend.SetRewriteHost(backendCfg.RewriteHost)
+		backend.SetProtocol(protocol)
 
-		// Configure circuit breaker
-		backend.GetCircuitBreaker().SetConfig(circuitbreaker.Config{
-			FailureThreshold: cfg.CircuitBreaker.FailureThreshold,
-			ResetTimeout:     time.Duration(cfg.CircuitBreaker.ResetTimeout),
-			HalfOpenLimit:    cfg.CircuitBreaker.HalfOpenLimit,
+		// Configure circuit breaker, reporting its state changes
+		backendID := backendCfg.ID
+		backend.GetCircuitBreaker().SetConfig(breakerConfig)
+		backend.GetCircuitBreaker().SetOnStateChange(func(from, to circuitbreaker.State) {
+			log.Printf("Circuit breaker for backend %s changed from %s to %s", backendID, from, to)
+			m.SetCircuitBreakerState(backendID, int(to))
 		})
+		m.SetCircuitBreakerState(backendID, int(circuitbreaker.Closed))
 
-		// Create health checker
-		checker := health.NewHTTPChecker(backendCfg.URL, health.Config{
-			Timeout:  time.Duration(cfg.HealthCheck.Timeout),
-			Path:     cfg.HealthCheck.Path,
-			Interval: time.Duration(cfg.HealthCheck.Interval),
-		})
+		// Create health checker of the backend's configured type
+		checkConfig, err := cfg.GetHealthCheckConfig(backendCfg)
+		if err != nil {
+			log.Fatalf("Failed to get health check config for backend %s: %v", backendCfg.ID, err)
+		}
+		checker, err := health.NewChecker(checkConfig)
+		if err != nil {
+			log.Fatalf("Failed to create health checker for backend %s: %v", backendCfg.ID, err)
+		}
 
-		// Add backend to balancer and scheduler
+		// Add backend to balancer, scheduler and outlier detection
 		b.AddBackend(backendCfg.ID, backend)
 		scheduler.AddBackend(backendCfg.ID, backend, checker)
+		if agentConfig, ok := cfg.GetAgentConfig(backendCfg); ok {
+			scheduler.SetAgent(backendCfg.ID, health.NewAgent(agentConfig))
+		}
+		if outliers != nil {
+			outliers.AddBackend(backend)
+		}
 	}
 
-	// Start health checks
+	// Start health checks and outlier detection
 	scheduler.Start()
+	if outliers != nil {
+		outliers.Start()
+	}
+
+	// Proxy raw TCP connections or UDP datagrams instead of HTTP requests
+	switch cfg.Server.Mode {
+	case config.ModeTCP:
+		serveTCP(cfg, b, m)
+		scheduler.Stop()
+		return
+	case config.ModeUDP:
+		serveUDP(cfg, b, m)
+		scheduler.Stop()
+		return
+	}
 
 	// Create HTTP server
 	server := &http.Server{
@@ -94,6 +153,12 @@ func main() {
 		Handler: p,
 	}
 
+	// Serve HTTP/2 over TLS, and over cleartext when h2c is enabled
+	server.Protocols = new(http.Protocols)
+	server.Protocols.SetHTTP1(true)
+	server.Protocols.SetHTTP2(true)
+	server.Protocols.SetUnencryptedHTTP2(cfg.Server.H2C)
+
 	// Initialize TLS if enabled
 	var tlsManager *tls.Manager
 	if cfg.Server.TLS.Enabled {
@@ -152,3 +217,57 @@ func main() {
 
 	log.Println("Server exited properly")
 }
+
+// serveTCP runs the load balancer in TCP mode until interrupted, then drains
+// open connections
+func serveTCP(cfg *config.Config, b balancer.Balancer, m *metrics.Metrics) {
+	p := tcpproxy.New(m, cfg.GetTCPConfig())
+	p.SetBalancer(b)
+
+	// Start proxy in a goroutine
+	go func() {
+		log.Printf("Starting TCP proxy on port %d", cfg.Server.Port)
+		if err := p.ListenAndServe(fmt.Sprintf(":%d", cfg.Server.Port)); err != nil && err != tcpproxy.ErrServerClosed {
+			log.Fatalf("Failed to start TCP proxy: %v", err)
+		}
+	}()
+
+	// Set up graceful shutdown
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	log.Println("Draining TCP connections...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.TCP.DrainTimeout))
+	defer cancel()
+
+	if err := p.Shutdown(ctx); err != nil {
+		log.Printf("Closed TCP connections still open after drain timeout: %v", err)
+	}
+
+	log.Println("TCP proxy exited properly")
+}
+
+// serveUDP runs the load balancer in UDP mode until interrupted
+func serveUDP(cfg *config.Config, b balancer.Balancer, m *metrics.Metrics) {
+	p := udpproxy.New(m, cfg.GetUDPConfig())
+	p.SetBalancer(b)
+
+	// Start proxy in a goroutine
+	go func() {
+		log.Printf("Starting UDP proxy on port %d", cfg.Server.Port)
+		if err := p.ListenAndServe(fmt.Sprintf(":%d", cfg.Server.Port)); err != nil && err != udpproxy.ErrServerClosed {
+			log.Fatalf("Failed to start UDP proxy: %v", err)
+		}
+	}()
+
+	// Set up graceful shutdown
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	log.Println("Shutting down UDP proxy...")
+	p.Close()
+	log.Println("UDP proxy exited properly")
+}
@@ -3,13 +3,26 @@ package backend
 import (
 	"sync"
 	"sync/atomic"
-	"time"
 
 	"load-balancer/internal/circuitbreaker"
 	"load-balancer/internal/retry"
 	"net/url"
 )
 
+// Protocol represents the HTTP protocol used to talk to a backend
+type Protocol string
+
+const (
+	// ProtocolAuto uses HTTP/1.1, negotiating HTTP/2 over TLS when offered
+	ProtocolAuto Protocol = ""
+	// ProtocolHTTP1 always uses HTTP/1.1
+	ProtocolHTTP1 Protocol = "http1"
+	// ProtocolHTTP2 requires HTTP/2 over TLS
+	ProtocolHTTP2 Protocol = "h2"
+	// ProtocolH2C uses cleartext HTTP/2 with prior knowledge
+	ProtocolH2C Protocol = "h2c"
+)
+
 // Backend represents a backend server
 type Backend struct {
 	id             string
@@ -20,6 +33,11 @@ type Backend struct {
 	mu             sync.RWMutex
 	circuitBreaker *circuitbreaker.CircuitBreaker
 	retryConfig    *retry.Config
+	rewriteHost    bool
+	protocol       Protocol
+	ejected        bool
+	draining       bool
+	maintenance    bool
 }
 
 // New creates a new backend
@@ -29,16 +47,12 @@ func New(id string, urlStr string, weight int) *Backend {
 		return nil
 	}
 	return &Backend{
-		id:           id,
-		url:          parsedURL,
-		weight:       weight,
-		IsHealthy:    true,
-		CurrentConns: 0,
-		circuitBreaker: circuitbreaker.New(circuitbreaker.Config{
-			FailureThreshold: 5,
-			ResetTimeout:     30 * time.Second,
-			HalfOpenLimit:    3,
-		}),
+		id:             id,
+		url:            parsedURL,
+		weight:         weight,
+		IsHealthy:      true,
+		CurrentConns:   0,
+		circuitBreaker: circuitbreaker.New(circuitbreaker.DefaultConfig()),
 	}
 }
 
@@ -54,6 +68,8 @@ func (b *Backend) URL() *url.URL {
 
 // Weight returns the backend weight
 func (b *Backend) Weight() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
 	return b.weight
 }
 
@@ -75,7 +91,59 @@ func (b *Backend) SetHealth(healthy bool) {
 func (b *Backend) IsAvailable() bool {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
-	return b.IsHealthy && b.circuitBreaker.AllowRequest()
+	return b.IsHealthy && !b.ejected && !b.draining && !b.maintenance && b.circuitBreaker.AllowRequest()
+}
+
+// CanServeSession checks if the backend can serve requests of an existing
+// sticky session. Unlike IsAvailable, draining backends still can.
+func (b *Backend) CanServeSession() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.IsHealthy && !b.ejected && !b.maintenance && b.circuitBreaker.AllowRequest()
+}
+
+// SetDraining sets whether the backend is draining: it receives no new
+// clients but keeps serving existing sticky sessions
+func (b *Backend) SetDraining(draining bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.draining = draining
+}
+
+// IsDraining reports whether the backend is draining
+func (b *Backend) IsDraining() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.draining
+}
+
+// SetMaintenance sets whether the backend is in maintenance and receives no
+// requests at all
+func (b *Backend) SetMaintenance(maintenance bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.maintenance = maintenance
+}
+
+// InMaintenance reports whether the backend is in maintenance
+func (b *Backend) InMaintenance() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.maintenance
+}
+
+// SetEjected sets whether the backend is ejected by outlier detection
+func (b *Backend) SetEjected(ejected bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.ejected = ejected
+}
+
+// IsEjected reports whether the backend is ejected by outlier detection
+func (b *Backend) IsEjected() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.ejected
 }
 
 // IncrementConnections increments the number of active connections
@@ -98,7 +166,35 @@ func (b *Backend) GetActiveConnections() int {
 
 // GetWeight returns the weight of the backend
 func (b *Backend) GetWeight() int {
-	return b.weight
+	return b.Weight()
+}
+
+// SetRewriteHost sets whether requests carry the backend's host instead of the client's Host header
+func (b *Backend) SetRewriteHost(rewrite bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rewriteHost = rewrite
+}
+
+// RewriteHost reports whether requests carry the backend's host as the Host header
+func (b *Backend) RewriteHost() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.rewriteHost
+}
+
+// SetProtocol sets the HTTP protocol used to talk to the backend
+func (b *Backend) SetProtocol(protocol Protocol) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.protocol = protocol
+}
+
+// Protocol returns the HTTP protocol used to talk to the backend
+func (b *Backend) Protocol() Protocol {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.protocol
 }
 
 // SetRetryConfig sets the retry configuration
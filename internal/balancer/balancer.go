@@ -2,6 +2,9 @@ package balancer
 
 import (
 	"errors"
+	"fmt"
+	"net/http"
+	"time"
 
 	"load-balancer/internal/backend"
 )
@@ -18,6 +21,12 @@ const (
 	Random Algorithm = "random"
 	// WeightedRoundRobin distributes requests based on backend weights
 	WeightedRoundRobin Algorithm = "weighted-round-robin"
+	// PowerOfTwoChoices samples two random backends and picks the less loaded one
+	PowerOfTwoChoices Algorithm = "power-of-two-choices"
+	// ConsistentHash routes requests by hashing a request key onto a ring of backends
+	ConsistentHash Algorithm = "consistent-hash"
+	// PeakEWMA sends requests to the backend with the lowest latency times in-flight requests
+	PeakEWMA Algorithm = "peak-ewma"
 )
 
 var (
@@ -29,8 +38,9 @@ var (
 
 // Balancer represents a load balancer
 type Balancer interface {
-	// Next returns the next backend to use
-	Next() (*backend.Backend, error)
+	// Next returns the next backend to use for the request. The request may be
+	// nil when no routing context is available.
+	Next(req *Request) (*backend.Backend, error)
 	// GetBackend returns a specific backend by ID
 	GetBackend(id string) (*backend.Backend, error)
 	// AddBackend adds a backend to the balancer
@@ -39,16 +49,89 @@ type Balancer interface {
 	RemoveBackend(id string)
 }
 
+// Observer is implemented by balancers that learn from completed requests
+type Observer interface {
+	// Observe records the response time of a request served by a backend
+	Observe(id string, latency time.Duration)
+}
+
+// Request carries the routing context for a single backend selection
+type Request struct {
+	// HTTP is the incoming request, nil for non-HTTP traffic
+	HTTP *http.Request
+	// ClientAddr is the network address of the client
+	ClientAddr string
+	// excluded holds the IDs of backends that must not be selected
+	excluded map[string]struct{}
+}
+
+// NewRequest creates a routing context for an HTTP request
+func NewRequest(r *http.Request) *Request {
+	return &Request{
+		HTTP:       r,
+		ClientAddr: r.RemoteAddr,
+	}
+}
+
+// Exclude prevents a backend from being selected for this request
+func (r *Request) Exclude(id string) {
+	if r.excluded == nil {
+		r.excluded = make(map[string]struct{})
+	}
+	r.excluded[id] = struct{}{}
+}
+
+// IsExcluded reports whether a backend must not be selected for this request
+func (r *Request) IsExcluded(id string) bool {
+	if r == nil {
+		return false
+	}
+	_, excluded := r.excluded[id]
+	return excluded
+}
+
+// eligible reports whether a backend can serve this request
+func (r *Request) eligible(b *backend.Backend) bool {
+	return !r.IsExcluded(b.ID()) && b.IsAvailable()
+}
+
+// eligibleSession reports whether a backend can serve this request as part
+// of an existing sticky session
+func (r *Request) eligibleSession(b *backend.Backend) bool {
+	return !r.IsExcluded(b.ID()) && b.CanServeSession()
+}
+
+// Config holds the balancer configuration
+type Config struct {
+	Algorithm string
+	Hash      HashConfig
+	EWMA      EWMAConfig
+}
+
 // New creates a new balancer with the specified algorithm
-func New(algorithm string) Balancer {
-	switch algorithm {
-	case "round-robin":
-		return newRoundRobin()
-	case "least-connections":
-		return newLeastConnections()
-	case "weighted-round-robin":
-		return newWeightedRoundRobin()
+func New(algorithm string) (Balancer, error) {
+	return NewWithConfig(Config{Algorithm: algorithm})
+}
+
+// NewWithConfig creates a new balancer from the given configuration
+func NewWithConfig(config Config) (Balancer, error) {
+	algorithm := config.Algorithm
+	switch Algorithm(algorithm) {
+	case RoundRobin:
+		return newRoundRobin(), nil
+	case LeastConnections:
+		return newLeastConnections(), nil
+	case Random:
+		return newRandom(), nil
+	case WeightedRoundRobin:
+		return newWeightedRoundRobin(), nil
+	case PowerOfTwoChoices:
+		return newPowerOfTwo(), nil
+	case ConsistentHash:
+		return newRingHash(config.Hash), nil
+	case PeakEWMA:
+		return newPeakEWMA(config.EWMA), nil
 	default:
-		return newRoundRobin()
+		return nil, fmt.Errorf("%w: %q", ErrUnknownAlgorithm, algorithm)
 	}
 }
@@ -1,11 +1,17 @@
 package balancer
 
 import (
+	"errors"
 	"fmt"
 	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
 	"testing"
+	"time"
 
 	"load-balancer/internal/backend"
+	"load-balancer/internal/session"
 )
 
 func TestNewBalancer(t *testing.T) {
@@ -16,11 +22,17 @@ func TestNewBalancer(t *testing.T) {
 		{"round-robin", "round-robin"},
 		{"least-connections", "least-connections"},
 		{"weighted-round-robin", "weighted-round-robin"},
+		{"random", "random"},
+		{"power-of-two-choices", "power-of-two-choices"},
+		{"consistent-hash", "consistent-hash"},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			b := New(tt.algo)
+			b, err := New(tt.algo)
+			if err != nil {
+				t.Fatalf("Expected no error for algorithm %s, got %v", tt.algo, err)
+			}
 			if b == nil {
 				t.Errorf("Expected non-nil balancer for algorithm %s", tt.algo)
 			}
@@ -28,8 +40,28 @@ func TestNewBalancer(t *testing.T) {
 	}
 }
 
+func TestNewUnknownAlgorithm(t *testing.T) {
+	b, err := New("does-not-exist")
+	if !errors.Is(err, ErrUnknownAlgorithm) {
+		t.Errorf("Expected ErrUnknownAlgorithm, got %v", err)
+	}
+	if b != nil {
+		t.Error("Expected nil balancer for unknown algorithm")
+	}
+}
+
+// mustNew creates a balancer or fails the test
+func mustNew(t *testing.T, algorithm string) Balancer {
+	t.Helper()
+	b, err := New(algorithm)
+	if err != nil {
+		t.Fatalf("Failed to create %s balancer: %v", algorithm, err)
+	}
+	return b
+}
+
 func TestAddRemoveBackend(t *testing.T) {
-	b := New("round-robin")
+	b := mustNew(t, "round-robin")
 	backend := backend.New("test", "http://localhost:8080", 1)
 
 	// Test adding backend
@@ -51,15 +83,15 @@ func TestAddRemoveBackend(t *testing.T) {
 }
 
 func TestGetBackendNoBackends(t *testing.T) {
-	b := New("round-robin")
-	_, err := b.Next()
+	b := mustNew(t, "round-robin")
+	_, err := b.Next(nil)
 	if err != ErrNoBackends {
 		t.Errorf("Expected ErrNoBackends, got %v", err)
 	}
 }
 
 func TestRoundRobin(t *testing.T) {
-	b := New("round-robin")
+	b := mustNew(t, "round-robin")
 
 	// Add three backends with different URLs
 	for i := 1; i <= 3; i++ {
@@ -71,7 +103,7 @@ func TestRoundRobin(t *testing.T) {
 	seen := make(map[string]int)
 	numRequests := 1000
 	for range make([]struct{}, numRequests) {
-		backend, err := b.Next()
+		backend, err := b.Next(nil)
 		if err != nil {
 			t.Fatalf("Next failed: %v", err)
 		}
@@ -90,7 +122,7 @@ func TestRoundRobin(t *testing.T) {
 }
 
 func TestLeastConnections(t *testing.T) {
-	b := New("least-connections")
+	b := mustNew(t, "least-connections")
 
 	// Add three backends with different URLs
 	for i := 1; i <= 3; i++ {
@@ -107,7 +139,7 @@ func TestLeastConnections(t *testing.T) {
 
 	// Get backend 100 times
 	for range make([]struct{}, 100) {
-		backend, err := b.Next()
+		backend, err := b.Next(nil)
 		if err != nil {
 			t.Fatalf("Next failed: %v", err)
 		}
@@ -118,7 +150,7 @@ func TestLeastConnections(t *testing.T) {
 }
 
 func TestWeightedRoundRobin(t *testing.T) {
-	b := New("weighted-round-robin")
+	b := mustNew(t, "weighted-round-robin")
 
 	// Add backends with different weights
 	weights := map[string]int{
@@ -136,7 +168,7 @@ func TestWeightedRoundRobin(t *testing.T) {
 	seen := make(map[string]int)
 	numRequests := 600
 	for range make([]struct{}, numRequests) {
-		backend, err := b.Next()
+		backend, err := b.Next(nil)
 		if err != nil {
 			t.Fatalf("Next failed: %v", err)
 		}
@@ -174,3 +206,442 @@ func TestWeightedRoundRobin(t *testing.T) {
 		}
 	}
 }
+
+func TestWeightedRoundRobinLiveWeights(t *testing.T) {
+	b := mustNew(t, "weighted-round-robin")
+	backend1 := backend.New("backend1", "http://localhost:8081", 1)
+	backend2 := backend.New("backend2", "http://localhost:8082", 1)
+	b.AddBackend("backend1", backend1)
+	b.AddBackend("backend2", backend2)
+
+	count := func() map[string]int {
+		seen := make(map[string]int)
+		for range make([]struct{}, 40) {
+			backend, err := b.Next(nil)
+			if err != nil {
+				t.Fatalf("Next failed: %v", err)
+			}
+			seen[backend.ID()]++
+		}
+		return seen
+	}
+
+	// Weights changed after AddBackend take effect
+	backend2.SetWeight(3)
+	if seen := count(); seen["backend1"] != 10 || seen["backend2"] != 30 {
+		t.Errorf("Distribution = %v, want backend1: 10, backend2: 30", seen)
+	}
+
+	// Backends with zero weight get no requests
+	backend2.SetWeight(0)
+	if seen := count(); seen["backend2"] != 0 {
+		t.Errorf("Distribution = %v, want no requests to backend2", seen)
+	}
+	backend1.SetWeight(0)
+	if _, err := b.Next(nil); err != ErrNoHealthyBackends {
+		t.Errorf("Next error = %v, want %v", err, ErrNoHealthyBackends)
+	}
+}
+
+func TestRandom(t *testing.T) {
+	b := mustNew(t, "random")
+
+	for i := 1; i <= 3; i++ {
+		backend := backend.New(fmt.Sprintf("backend%d", i), fmt.Sprintf("http://localhost:808%d", i), 1)
+		b.AddBackend(fmt.Sprintf("backend%d", i), backend)
+	}
+
+	// Mark one backend as unhealthy
+	down, _ := b.GetBackend("backend3")
+	down.SetHealth(false)
+
+	seen := make(map[string]int)
+	numRequests := 1000
+	for range make([]struct{}, numRequests) {
+		backend, err := b.Next(nil)
+		if err != nil {
+			t.Fatalf("Next failed: %v", err)
+		}
+		seen[backend.ID()]++
+	}
+
+	if seen["backend3"] != 0 {
+		t.Errorf("Expected no requests to unhealthy backend, got %d", seen["backend3"])
+	}
+
+	// Check distribution - allow for 20% deviation from expected value
+	expectedCount := float64(numRequests) / 2
+	for _, id := range []string{"backend1", "backend2"} {
+		deviation := math.Abs(float64(seen[id])-expectedCount) / expectedCount
+		if deviation > 0.2 {
+			t.Errorf("Uneven distribution for %s: got %d requests, expected around %d (±20%%)",
+				id, seen[id], int(expectedCount))
+		}
+	}
+
+	// No healthy backends left
+	for _, id := range []string{"backend1", "backend2"} {
+		backend, _ := b.GetBackend(id)
+		backend.SetHealth(false)
+	}
+	if _, err := b.Next(nil); err != ErrNoHealthyBackends {
+		t.Errorf("Expected ErrNoHealthyBackends, got %v", err)
+	}
+}
+
+func TestPowerOfTwoChoices(t *testing.T) {
+	b := mustNew(t, "power-of-two-choices")
+
+	for i := 1; i <= 2; i++ {
+		backend := backend.New(fmt.Sprintf("backend%d", i), fmt.Sprintf("http://localhost:808%d", i), 1)
+		b.AddBackend(fmt.Sprintf("backend%d", i), backend)
+	}
+
+	// With two backends both are always sampled, so the less loaded one must win
+	busy, _ := b.GetBackend("backend1")
+	busy.IncrementConnections()
+
+	for range make([]struct{}, 100) {
+		backend, err := b.Next(nil)
+		if err != nil {
+			t.Fatalf("Next failed: %v", err)
+		}
+		if backend.ID() != "backend2" {
+			t.Errorf("Expected least loaded backend2, got %s", backend.ID())
+		}
+	}
+
+	// Unavailable backends are never sampled, even when idle
+	idle, _ := b.GetBackend("backend2")
+	idle.SetHealth(false)
+	backend, err := b.Next(nil)
+	if err != nil {
+		t.Fatalf("Next failed: %v", err)
+	}
+	if backend.ID() != "backend1" {
+		t.Errorf("Expected only available backend1, got %s", backend.ID())
+	}
+}
+
+func TestConsistentHash(t *testing.T) {
+	rh := newRingHash(HashConfig{Key: HashKeyPath})
+
+	for i := 1; i <= 4; i++ {
+		backend := backend.New(fmt.Sprintf("backend%d", i), fmt.Sprintf("http://localhost:808%d", i), 1)
+		rh.AddBackend(fmt.Sprintf("backend%d", i), backend)
+	}
+
+	// Record the owner of each key
+	numKeys := 10000
+	owners := make(map[string]string, numKeys)
+	for i := 0; i < numKeys; i++ {
+		key := fmt.Sprintf("/object/%d", i)
+		backend, err := rh.nextForKey(key, nil)
+		if err != nil {
+			t.Fatalf("nextForKey failed: %v", err)
+		}
+		owners[key] = backend.ID()
+	}
+
+	// The same key must always map to the same backend
+	for key, owner := range owners {
+		backend, _ := rh.nextForKey(key, nil)
+		if backend.ID() != owner {
+			t.Fatalf("Key %s moved from %s to %s without membership change", key, owner, backend.ID())
+		}
+	}
+
+	// Adding a fifth backend should only move about 1/5 of the keys, all to the new backend
+	rh.AddBackend("backend5", backend.New("backend5", "http://localhost:8085", 1))
+	moved := 0
+	for key, owner := range owners {
+		backend, _ := rh.nextForKey(key, nil)
+		if backend.ID() == owner {
+			continue
+		}
+		moved++
+		if backend.ID() != "backend5" {
+			t.Errorf("Key %s moved from %s to existing backend %s", key, owner, backend.ID())
+		}
+	}
+	if ratio := float64(moved) / float64(numKeys); ratio < 0.1 || ratio > 0.3 {
+		t.Errorf("Expected about 20%% of keys to move, got %.1f%%", ratio*100)
+	}
+
+	// Removing it again should restore the original owners
+	rh.RemoveBackend("backend5")
+	for key, owner := range owners {
+		backend, _ := rh.nextForKey(key, nil)
+		if backend.ID() != owner {
+			t.Fatalf("Key %s owned by %s after removal, expected %s", key, backend.ID(), owner)
+		}
+	}
+}
+
+func TestConsistentHashBoundedLoad(t *testing.T) {
+	rh := newRingHash(HashConfig{Key: HashKeyPath, LoadFactor: 1.25})
+
+	for i := 1; i <= 3; i++ {
+		backend := backend.New(fmt.Sprintf("backend%d", i), fmt.Sprintf("http://localhost:808%d", i), 1)
+		rh.AddBackend(fmt.Sprintf("backend%d", i), backend)
+	}
+
+	owner, err := rh.nextForKey("/hot", nil)
+	if err != nil {
+		t.Fatalf("nextForKey failed: %v", err)
+	}
+
+	// Overload the owner of the hot key
+	for range make([]struct{}, 10) {
+		owner.IncrementConnections()
+	}
+
+	backend, err := rh.nextForKey("/hot", nil)
+	if err != nil {
+		t.Fatalf("nextForKey failed: %v", err)
+	}
+	if backend.ID() == owner.ID() {
+		t.Errorf("Expected hot key to spill over from overloaded backend %s", owner.ID())
+	}
+
+	// Unavailable owners are skipped as well
+	for range make([]struct{}, 10) {
+		owner.DecrementConnections()
+	}
+	owner.SetHealth(false)
+	backend, err = rh.nextForKey("/hot", nil)
+	if err != nil {
+		t.Fatalf("nextForKey failed: %v", err)
+	}
+	if backend.ID() == owner.ID() {
+		t.Errorf("Expected unhealthy backend %s to be skipped", owner.ID())
+	}
+}
+
+func TestHashConfigKeyFor(t *testing.T) {
+	req := httptest.NewRequest("GET", "/users/42", nil)
+	req.RemoteAddr = "10.0.0.1:12345"
+	req.Header.Set("X-User-ID", "alice")
+	req.AddCookie(&http.Cookie{Name: "sid", Value: "abc"})
+
+	tests := []struct {
+		config HashConfig
+		want   string
+	}{
+		{HashConfig{Key: HashKeyPath}, "/users/42"},
+		{HashConfig{Key: HashKeyHeader, Name: "X-User-ID"}, "alice"},
+		{HashConfig{Key: HashKeyCookie, Name: "sid"}, "abc"},
+		{HashConfig{Key: HashKeyClientIP}, "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.config.KeyFor(NewRequest(req)); got != tt.want {
+			t.Errorf("KeyFor(%s) = %q, want %q", tt.config.Key, got, tt.want)
+		}
+	}
+}
+
+func TestNextExcludesBackends(t *testing.T) {
+	algorithms := []string{
+		"round-robin",
+		"least-connections",
+		"weighted-round-robin",
+		"random",
+		"power-of-two-choices",
+		"consistent-hash",
+	}
+
+	for _, algo := range algorithms {
+		t.Run(algo, func(t *testing.T) {
+			b := mustNew(t, algo)
+			for i := 1; i <= 3; i++ {
+				backend := backend.New(fmt.Sprintf("backend%d", i), fmt.Sprintf("http://localhost:808%d", i), 1)
+				b.AddBackend(fmt.Sprintf("backend%d", i), backend)
+			}
+
+			req := NewRequest(httptest.NewRequest("GET", "/", nil))
+			req.Exclude("backend1")
+			req.Exclude("backend2")
+
+			for range make([]struct{}, 50) {
+				backend, err := b.Next(req)
+				if err != nil {
+					t.Fatalf("Next failed: %v", err)
+				}
+				if backend.ID() != "backend3" {
+					t.Fatalf("Expected only non-excluded backend3, got %s", backend.ID())
+				}
+			}
+
+			req.Exclude("backend3")
+			if _, err := b.Next(req); err != ErrNoHealthyBackends {
+				t.Errorf("Expected ErrNoHealthyBackends with all backends excluded, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSticky(t *testing.T) {
+	sessions := session.NewManager(session.Config{
+		Enabled: true,
+		Type:    session.IPBased,
+	})
+	defer sessions.Stop()
+
+	b := NewSticky(mustNew(t, "round-robin"), sessions)
+	for i := 1; i <= 3; i++ {
+		backend := backend.New(fmt.Sprintf("backend%d", i), fmt.Sprintf("http://localhost:808%d", i), 1)
+		b.AddBackend(fmt.Sprintf("backend%d", i), backend)
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	sessions.SetBackendID(r, httptest.NewRecorder(), "backend2")
+
+	// Requests with a session stick to their backend
+	for range make([]struct{}, 10) {
+		backend, err := b.Next(NewRequest(r))
+		if err != nil {
+			t.Fatalf("Next failed: %v", err)
+		}
+		if backend.ID() != "backend2" {
+			t.Errorf("Expected sticky backend2, got %s", backend.ID())
+		}
+	}
+
+	// Excluding the session backend falls back to the wrapped balancer
+	req := NewRequest(r)
+	req.Exclude("backend2")
+	backend, err := b.Next(req)
+	if err != nil {
+		t.Fatalf("Next failed: %v", err)
+	}
+	if backend.ID() == "backend2" {
+		t.Error("Expected excluded session backend to be skipped")
+	}
+
+	// Draining backends keep their sessions but get no new clients
+	draining, _ := b.GetBackend("backend2")
+	draining.SetDraining(true)
+	if backend, err := b.Next(NewRequest(r)); err != nil || backend.ID() != "backend2" {
+		t.Errorf("Expected draining session backend2, got %v, %v", backend, err)
+	}
+	other := httptest.NewRequest("GET", "/", nil)
+	other.RemoteAddr = "192.0.2.2:1234"
+	for range make([]struct{}, 10) {
+		backend, err := b.Next(NewRequest(other))
+		if err != nil {
+			t.Fatalf("Next failed: %v", err)
+		}
+		if backend.ID() == "backend2" {
+			t.Error("Expected draining backend2 to get no new clients")
+		}
+	}
+}
+
+func TestPeakEWMA(t *testing.T) {
+	b := mustNew(t, "peak-ewma")
+	for i := 1; i <= 2; i++ {
+		backend := backend.New(fmt.Sprintf("backend%d", i), fmt.Sprintf("http://localhost:808%d", i), 1)
+		b.AddBackend(fmt.Sprintf("backend%d", i), backend)
+	}
+
+	observer, ok := b.(Observer)
+	if !ok {
+		t.Fatal("Expected peak EWMA balancer to implement Observer")
+	}
+	observer.Observe("backend1", 100*time.Millisecond)
+	observer.Observe("backend2", 10*time.Millisecond)
+
+	// The faster backend wins while load is equal
+	for range make([]struct{}, 20) {
+		backend, err := b.Next(nil)
+		if err != nil {
+			t.Fatalf("Next failed: %v", err)
+		}
+		if backend.ID() != "backend2" {
+			t.Fatalf("Expected faster backend2, got %s", backend.ID())
+		}
+	}
+
+	// Enough in-flight requests make the slower backend the better choice
+	fast, _ := b.GetBackend("backend2")
+	for range make([]struct{}, 20) {
+		fast.IncrementConnections()
+	}
+	backend, err := b.Next(nil)
+	if err != nil {
+		t.Fatalf("Next failed: %v", err)
+	}
+	if backend.ID() != "backend1" {
+		t.Errorf("Expected backend1 while backend2 is loaded, got %s", backend.ID())
+	}
+	for range make([]struct{}, 20) {
+		fast.DecrementConnections()
+	}
+
+	// Latency spikes are adopted immediately
+	observer.Observe("backend2", time.Second)
+	backend, err = b.Next(nil)
+	if err != nil {
+		t.Fatalf("Next failed: %v", err)
+	}
+	if backend.ID() != "backend1" {
+		t.Errorf("Expected backend1 after backend2 latency spike, got %s", backend.ID())
+	}
+}
+
+func TestRoundRobinSkipsUnavailable(t *testing.T) {
+	b := mustNew(t, "round-robin")
+	for i := 1; i <= 3; i++ {
+		backend := backend.New(fmt.Sprintf("backend%d", i), fmt.Sprintf("http://localhost:808%d", i), 1)
+		b.AddBackend(fmt.Sprintf("backend%d", i), backend)
+	}
+
+	// Mark one backend unhealthy and open the circuit breaker of another
+	down, _ := b.GetBackend("backend1")
+	down.SetHealth(false)
+	open, _ := b.GetBackend("backend2")
+	for range make([]struct{}, 5) {
+		open.GetCircuitBreaker().RecordFailure()
+	}
+
+	for range make([]struct{}, 10) {
+		backend, err := b.Next(nil)
+		if err != nil {
+			t.Fatalf("Next failed: %v", err)
+		}
+		if backend.ID() != "backend3" {
+			t.Errorf("Expected only available backend3, got %s", backend.ID())
+		}
+	}
+
+	last, _ := b.GetBackend("backend3")
+	last.SetHealth(false)
+	if _, err := b.Next(nil); err != ErrNoHealthyBackends {
+		t.Errorf("Expected ErrNoHealthyBackends, got %v", err)
+	}
+}
+
+func TestRoundRobinConcurrent(t *testing.T) {
+	b := mustNew(t, "round-robin")
+	for i := 1; i <= 3; i++ {
+		backend := backend.New(fmt.Sprintf("backend%d", i), fmt.Sprintf("http://localhost:808%d", i), 1)
+		b.AddBackend(fmt.Sprintf("backend%d", i), backend)
+	}
+
+	// Run with -race to detect unsynchronized access to the rotation index
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range make([]struct{}, 100) {
+				if _, err := b.Next(nil); err != nil {
+					t.Errorf("Next failed: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
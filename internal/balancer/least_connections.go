@@ -20,7 +20,7 @@ func newLeastConnections() *leastConnections {
 }
 
 // Next returns the backend with the least active connections
-func (lc *leastConnections) Next() (*backend.Backend, error) {
+func (lc *leastConnections) Next(req *Request) (*backend.Backend, error) {
 	lc.mu.RLock()
 	defer lc.mu.RUnlock()
 
@@ -32,7 +32,7 @@ func (lc *leastConnections) Next() (*backend.Backend, error) {
 	minConns := -1
 
 	for _, backend := range lc.backends {
-		if !backend.IsAvailable() {
+		if !req.eligible(backend) {
 			continue
 		}
 		conns := backend.GetActiveConnections()
@@ -0,0 +1,175 @@
+package balancer
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"load-balancer/internal/backend"
+)
+
+// EWMAConfig holds the peak EWMA configuration
+type EWMAConfig struct {
+	// DecayTime is the time constant over which past latencies lose weight
+	DecayTime time.Duration
+}
+
+// ewma tracks the peak-sensitive moving average latency of a backend
+type ewma struct {
+	cost  float64
+	stamp time.Time
+}
+
+// peakEWMA implements the peak EWMA load balancing algorithm. Each backend is
+// scored by its moving average latency multiplied by its in-flight requests,
+// and the backend with the lowest score is picked.
+type peakEWMA struct {
+	config   EWMAConfig
+	backends map[string]*backend.Backend
+	stats    map[string]*ewma
+	mu       sync.RWMutex
+	keys     []string
+}
+
+// newPeakEWMA creates a new peak EWMA balancer
+func newPeakEWMA(config EWMAConfig) *peakEWMA {
+	if config.DecayTime <= 0 {
+		config.DecayTime = 10 * time.Second
+	}
+
+	return &peakEWMA{
+		config:   config,
+		backends: make(map[string]*backend.Backend),
+		stats:    make(map[string]*ewma),
+		keys:     make([]string, 0),
+	}
+}
+
+// Next returns the available backend with the lowest latency score
+func (pe *peakEWMA) Next(req *Request) (*backend.Backend, error) {
+	pe.mu.RLock()
+	defer pe.mu.RUnlock()
+
+	if len(pe.backends) == 0 {
+		return nil, ErrNoBackends
+	}
+
+	now := time.Now()
+
+	// Backends without observations are assumed to be as fast as the pool average
+	var observed int
+	var total float64
+	for _, stat := range pe.stats {
+		if cost := pe.decayed(stat, now); cost > 0 {
+			total += cost
+			observed++
+		}
+	}
+	defaultCost := 1.0
+	if observed > 0 {
+		defaultCost = total / float64(observed)
+	}
+
+	var selected *backend.Backend
+	minScore := math.Inf(1)
+
+	// Start at a random offset so ties don't always favour the same backend
+	offset := rand.Intn(len(pe.keys))
+	for i := range pe.keys {
+		id := pe.keys[(offset+i)%len(pe.keys)]
+		b := pe.backends[id]
+		if !req.eligible(b) {
+			continue
+		}
+
+		cost := pe.decayed(pe.stats[id], now)
+		if cost <= 0 {
+			cost = defaultCost
+		}
+		score := cost * float64(b.GetActiveConnections()+1)
+		if score < minScore {
+			minScore = score
+			selected = b
+		}
+	}
+
+	if selected == nil {
+		return nil, ErrNoHealthyBackends
+	}
+
+	return selected, nil
+}
+
+// Observe records the response time of a request served by a backend. Latency
+// spikes are adopted immediately while improvements are averaged in.
+func (pe *peakEWMA) Observe(id string, latency time.Duration) {
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+
+	stat, exists := pe.stats[id]
+	if !exists {
+		return
+	}
+
+	now := time.Now()
+	rtt := float64(latency)
+	if stat.stamp.IsZero() || rtt > stat.cost {
+		stat.cost = rtt
+	} else {
+		w := math.Exp(-float64(now.Sub(stat.stamp)) / float64(pe.config.DecayTime))
+		stat.cost = stat.cost*w + rtt*(1-w)
+	}
+	stat.stamp = now
+}
+
+// decayed returns the backend's cost decayed towards zero for the time since its
+// last observation, so idle backends are eventually tried again
+func (pe *peakEWMA) decayed(stat *ewma, now time.Time) float64 {
+	if stat == nil || stat.stamp.IsZero() {
+		return 0
+	}
+	return stat.cost * math.Exp(-float64(now.Sub(stat.stamp))/float64(pe.config.DecayTime))
+}
+
+// GetBackend returns a specific backend by ID
+func (pe *peakEWMA) GetBackend(id string) (*backend.Backend, error) {
+	pe.mu.RLock()
+	defer pe.mu.RUnlock()
+
+	backend, exists := pe.backends[id]
+	if !exists {
+		return nil, ErrBackendNotFound
+	}
+
+	return backend, nil
+}
+
+// AddBackend adds a backend to the balancer
+func (pe *peakEWMA) AddBackend(id string, backend *backend.Backend) {
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+
+	if _, exists := pe.backends[id]; !exists {
+		pe.keys = append(pe.keys, id)
+		pe.stats[id] = &ewma{}
+	}
+	pe.backends[id] = backend
+}
+
+// RemoveBackend removes a backend from the balancer
+func (pe *peakEWMA) RemoveBackend(id string) {
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+
+	delete(pe.backends, id)
+	delete(pe.stats, id)
+
+	// Remove from keys slice
+	for i, key := range pe.keys {
+		if key == id {
+			pe.keys = append(pe.keys[:i], pe.keys[i+1:]...)
+			break
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package balancer
+
+import (
+	"math/rand"
+	"sync"
+
+	"load-balancer/internal/backend"
+)
+
+// powerOfTwo implements the "power of two random choices" algorithm: it samples
+// two available backends at random and picks the one with fewer active connections
+type powerOfTwo struct {
+	backends map[string]*backend.Backend
+	mu       sync.RWMutex
+	keys     []string
+}
+
+// newPowerOfTwo creates a new power of two choices balancer
+func newPowerOfTwo() *powerOfTwo {
+	return &powerOfTwo{
+		backends: make(map[string]*backend.Backend),
+		keys:     make([]string, 0),
+	}
+}
+
+// Next returns the less loaded of two randomly sampled available backends
+func (p *powerOfTwo) Next(req *Request) (*backend.Backend, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if len(p.backends) == 0 {
+		return nil, ErrNoBackends
+	}
+
+	available := make([]*backend.Backend, 0, len(p.keys))
+	for _, key := range p.keys {
+		if b := p.backends[key]; req.eligible(b) {
+			available = append(available, b)
+		}
+	}
+
+	switch len(available) {
+	case 0:
+		return nil, ErrNoHealthyBackends
+	case 1:
+		return available[0], nil
+	}
+
+	// Sample two distinct backends
+	i := rand.Intn(len(available))
+	j := rand.Intn(len(available) - 1)
+	if j >= i {
+		j++
+	}
+
+	first, second := available[i], available[j]
+	if second.GetActiveConnections() < first.GetActiveConnections() {
+		return second, nil
+	}
+	return first, nil
+}
+
+// GetBackend returns a specific backend by ID
+func (p *powerOfTwo) GetBackend(id string) (*backend.Backend, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	backend, exists := p.backends[id]
+	if !exists {
+		return nil, ErrBackendNotFound
+	}
+
+	return backend, nil
+}
+
+// AddBackend adds a backend to the balancer
+func (p *powerOfTwo) AddBackend(id string, backend *backend.Backend) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, exists := p.backends[id]; !exists {
+		p.keys = append(p.keys, id)
+	}
+	p.backends[id] = backend
+}
+
+// RemoveBackend removes a backend from the balancer
+func (p *powerOfTwo) RemoveBackend(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.backends, id)
+
+	// Remove from keys slice
+	for i, key := range p.keys {
+		if key == id {
+			p.keys = append(p.keys[:i], p.keys[i+1:]...)
+			break
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package balancer
+
+import (
+	"math/rand"
+	"sync"
+
+	"load-balancer/internal/backend"
+)
+
+// random implements the uniform random load balancing algorithm
+type random struct {
+	backends map[string]*backend.Backend
+	mu       sync.RWMutex
+	keys     []string
+}
+
+// newRandom creates a new random balancer
+func newRandom() *random {
+	return &random{
+		backends: make(map[string]*backend.Backend),
+		keys:     make([]string, 0),
+	}
+}
+
+// Next returns a randomly selected available backend
+func (r *random) Next(req *Request) (*backend.Backend, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.backends) == 0 {
+		return nil, ErrNoBackends
+	}
+
+	available := make([]*backend.Backend, 0, len(r.keys))
+	for _, key := range r.keys {
+		if b := r.backends[key]; req.eligible(b) {
+			available = append(available, b)
+		}
+	}
+
+	if len(available) == 0 {
+		return nil, ErrNoHealthyBackends
+	}
+
+	return available[rand.Intn(len(available))], nil
+}
+
+// GetBackend returns a specific backend by ID
+func (r *random) GetBackend(id string) (*backend.Backend, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	backend, exists := r.backends[id]
+	if !exists {
+		return nil, ErrBackendNotFound
+	}
+
+	return backend, nil
+}
+
+// AddBackend adds a backend to the balancer
+func (r *random) AddBackend(id string, backend *backend.Backend) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.backends[id]; !exists {
+		r.keys = append(r.keys, id)
+	}
+	r.backends[id] = backend
+}
+
+// RemoveBackend removes a backend from the balancer
+func (r *random) RemoveBackend(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.backends, id)
+
+	// Remove from keys slice
+	for i, key := range r.keys {
+		if key == id {
+			r.keys = append(r.keys[:i], r.keys[i+1:]...)
+			break
+		}
+	}
+}
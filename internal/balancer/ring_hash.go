@@ -0,0 +1,244 @@
+package balancer
+
+import (
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+
+	"load-balancer/internal/backend"
+)
+
+// HashKeySource represents the request attribute hashed by the consistent hash balancer
+type HashKeySource string
+
+const (
+	// HashKeyPath hashes the request path
+	HashKeyPath HashKeySource = "path"
+	// HashKeyHeader hashes the value of a request header
+	HashKeyHeader HashKeySource = "header"
+	// HashKeyCookie hashes the value of a request cookie
+	HashKeyCookie HashKeySource = "cookie"
+	// HashKeyClientIP hashes the client IP address
+	HashKeyClientIP HashKeySource = "ip"
+)
+
+// HashConfig holds the consistent hash configuration
+type HashConfig struct {
+	// Key selects the request attribute to hash
+	Key HashKeySource
+	// Name is the header or cookie name when Key is header or cookie
+	Name string
+	// VirtualNodes is the number of ring points per unit of backend weight
+	VirtualNodes int
+	// LoadFactor caps a backend's load at LoadFactor times the average load.
+	// Zero disables bounded loads.
+	LoadFactor float64
+}
+
+// KeyFor extracts the hash key from a routing context
+func (c HashConfig) KeyFor(req *Request) string {
+	if c.Key == HashKeyClientIP {
+		host, _, err := net.SplitHostPort(req.ClientAddr)
+		if err != nil {
+			return req.ClientAddr
+		}
+		return host
+	}
+
+	r := req.HTTP
+	if r == nil {
+		return ""
+	}
+
+	switch c.Key {
+	case HashKeyHeader:
+		return r.Header.Get(c.Name)
+	case HashKeyCookie:
+		cookie, err := r.Cookie(c.Name)
+		if err != nil {
+			return ""
+		}
+		return cookie.Value
+	default:
+		return r.URL.Path
+	}
+}
+
+// ringEntry is a single virtual node on the hash ring
+type ringEntry struct {
+	hash uint64
+	id   string
+}
+
+// ringHash implements consistent hashing on a ring of virtual nodes with bounded loads
+type ringHash struct {
+	config   HashConfig
+	backends map[string]*backend.Backend
+	mu       sync.RWMutex
+	ring     []ringEntry
+}
+
+// newRingHash creates a new consistent hash balancer
+func newRingHash(config HashConfig) *ringHash {
+	if config.Key == "" {
+		config.Key = HashKeyPath
+	}
+	if config.VirtualNodes <= 0 {
+		config.VirtualNodes = 100
+	}
+	if config.LoadFactor != 0 && config.LoadFactor < 1 {
+		config.LoadFactor = 1
+	}
+
+	return &ringHash{
+		config:   config,
+		backends: make(map[string]*backend.Backend),
+		ring:     make([]ringEntry, 0),
+	}
+}
+
+// Next returns the backend owning the request's hash key. Without a routing
+// context a random key is used.
+func (rh *ringHash) Next(req *Request) (*backend.Backend, error) {
+	key := strconv.FormatUint(rand.Uint64(), 10)
+	if req != nil {
+		key = rh.config.KeyFor(req)
+	}
+	return rh.nextForKey(key, req)
+}
+
+// nextForKey walks the ring clockwise from the key's hash and returns the first
+// eligible backend whose load is below the bounded-load cap
+func (rh *ringHash) nextForKey(key string, req *Request) (*backend.Backend, error) {
+	rh.mu.RLock()
+	defer rh.mu.RUnlock()
+
+	if len(rh.backends) == 0 {
+		return nil, ErrNoBackends
+	}
+
+	// Compute the load cap from the currently available backends
+	available, totalLoad := 0, 0
+	for _, b := range rh.backends {
+		if req.eligible(b) {
+			available++
+			totalLoad += b.GetActiveConnections()
+		}
+	}
+	if available == 0 {
+		return nil, ErrNoHealthyBackends
+	}
+	loadCap := math.MaxInt
+	if rh.config.LoadFactor > 0 {
+		loadCap = int(math.Ceil(rh.config.LoadFactor * float64(totalLoad+1) / float64(available)))
+	}
+
+	h := hashKey(key)
+	start := sort.Search(len(rh.ring), func(i int) bool {
+		return rh.ring[i].hash >= h
+	})
+
+	var fallback *backend.Backend
+	visited := make(map[string]bool, len(rh.backends))
+	for i := 0; i < len(rh.ring) && len(visited) < len(rh.backends); i++ {
+		entry := rh.ring[(start+i)%len(rh.ring)]
+		if visited[entry.id] {
+			continue
+		}
+		visited[entry.id] = true
+
+		b := rh.backends[entry.id]
+		if !req.eligible(b) {
+			continue
+		}
+		if fallback == nil {
+			fallback = b
+		}
+		if b.GetActiveConnections()+1 <= loadCap {
+			return b, nil
+		}
+	}
+
+	// Every available backend is at the cap; stay with the key's owner
+	if fallback == nil {
+		return nil, ErrNoHealthyBackends
+	}
+	return fallback, nil
+}
+
+// GetBackend returns a specific backend by ID
+func (rh *ringHash) GetBackend(id string) (*backend.Backend, error) {
+	rh.mu.RLock()
+	defer rh.mu.RUnlock()
+
+	backend, exists := rh.backends[id]
+	if !exists {
+		return nil, ErrBackendNotFound
+	}
+
+	return backend, nil
+}
+
+// AddBackend adds a backend to the balancer
+func (rh *ringHash) AddBackend(id string, backend *backend.Backend) {
+	rh.mu.Lock()
+	defer rh.mu.Unlock()
+
+	rh.backends[id] = backend
+	rh.rebuild()
+}
+
+// RemoveBackend removes a backend from the balancer
+func (rh *ringHash) RemoveBackend(id string) {
+	rh.mu.Lock()
+	defer rh.mu.Unlock()
+
+	delete(rh.backends, id)
+	rh.rebuild()
+}
+
+// rebuild recomputes the ring from the current backends. Virtual node positions
+// depend only on the backend ID, so membership changes move only the keys owned
+// by the added or removed backend.
+func (rh *ringHash) rebuild() {
+	ring := make([]ringEntry, 0, len(rh.ring))
+	for id, b := range rh.backends {
+		weight := b.Weight()
+		if weight < 1 {
+			weight = 1
+		}
+		for i := 0; i < rh.config.VirtualNodes*weight; i++ {
+			ring = append(ring, ringEntry{
+				hash: hashKey(id + "#" + strconv.Itoa(i)),
+				id:   id,
+			})
+		}
+	}
+
+	sort.Slice(ring, func(i, j int) bool {
+		if ring[i].hash == ring[j].hash {
+			return ring[i].id < ring[j].id
+		}
+		return ring[i].hash < ring[j].hash
+	})
+	rh.ring = ring
+}
+
+// hashKey hashes a key onto the ring
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+
+	// FNV clusters similar inputs, so finish with a 64-bit mixer
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
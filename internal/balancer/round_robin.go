@@ -23,19 +23,25 @@ func newRoundRobin() *roundRobin {
 }
 
 // Next returns the next backend to use
-func (rb *roundRobin) Next() (*backend.Backend, error) {
-	rb.mu.RLock()
-	defer rb.mu.RUnlock()
+func (rb *roundRobin) Next(req *Request) (*backend.Backend, error) {
+	// Advancing current mutates state, so a write lock is required
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
 
 	if len(rb.backends) == 0 {
 		return nil, ErrNoBackends
 	}
 
-	// Get the next backend
-	backend := rb.backends[rb.keys[rb.current]]
-	rb.current = (rb.current + 1) % len(rb.keys)
+	// Get the next backend that is available and not excluded for this request
+	for range rb.keys {
+		backend := rb.backends[rb.keys[rb.current]]
+		rb.current = (rb.current + 1) % len(rb.keys)
+		if req.eligible(backend) {
+			return backend, nil
+		}
+	}
 
-	return backend, nil
+	return nil, ErrNoHealthyBackends
 }
 
 // GetBackend returns a specific backend by ID
@@ -0,0 +1,45 @@
+package balancer
+
+import (
+	"time"
+
+	"load-balancer/internal/backend"
+	"load-balancer/internal/session"
+)
+
+// sticky wraps a balancer and routes requests with an active sticky session
+// back to the backend that served them
+type sticky struct {
+	Balancer
+	sessions *session.Manager
+}
+
+// NewSticky wraps a balancer with sticky session routing. Requests without a
+// session, or whose session backend is unavailable or excluded, fall back to
+// the wrapped balancer. Draining backends keep their sessions.
+func NewSticky(b Balancer, sessions *session.Manager) Balancer {
+	return &sticky{
+		Balancer: b,
+		sessions: sessions,
+	}
+}
+
+// Next returns the session backend if it can serve the request, otherwise the
+// next backend from the wrapped balancer
+func (s *sticky) Next(req *Request) (*backend.Backend, error) {
+	if req != nil && req.HTTP != nil {
+		if id := s.sessions.GetBackendID(req.HTTP); id != "" {
+			if b, err := s.Balancer.GetBackend(id); err == nil && req.eligibleSession(b) {
+				return b, nil
+			}
+		}
+	}
+	return s.Balancer.Next(req)
+}
+
+// Observe forwards latency observations to the wrapped balancer
+func (s *sticky) Observe(id string, latency time.Duration) {
+	if o, ok := s.Balancer.(Observer); ok {
+		o.Observe(id, latency)
+	}
+}
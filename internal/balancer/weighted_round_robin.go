@@ -6,32 +6,28 @@ import (
 	"load-balancer/internal/backend"
 )
 
-// weightedRoundRobin implements the weighted round-robin load balancing algorithm
+// weightedRoundRobin implements the weighted round-robin load balancing
+// algorithm. Weights are read from the backends on every pick, so SetWeight
+// takes effect immediately; backends with a weight of zero get no requests.
 type weightedRoundRobin struct {
 	backends map[string]*backend.Backend
 	mu       sync.RWMutex
-	current  int
-	weights  []int
 	keys     []string
 	// Track the current weight for each backend
 	currentWeights []int
-	// Track the maximum weight
-	maxWeight int
 }
 
 // newWeightedRoundRobin creates a new weighted round-robin balancer
 func newWeightedRoundRobin() *weightedRoundRobin {
 	return &weightedRoundRobin{
 		backends:       make(map[string]*backend.Backend),
-		weights:        make([]int, 0),
 		keys:           make([]string, 0),
 		currentWeights: make([]int, 0),
-		maxWeight:      0,
 	}
 }
 
 // Next returns the next backend based on weights
-func (wrr *weightedRoundRobin) Next() (*backend.Backend, error) {
+func (wrr *weightedRoundRobin) Next(req *Request) (*backend.Backend, error) {
 	wrr.mu.Lock()
 	defer wrr.mu.Unlock()
 
@@ -45,14 +41,16 @@ func (wrr *weightedRoundRobin) Next() (*backend.Backend, error) {
 		maxWeight   int = -1
 	)
 
-	for i, b := range wrr.keys {
-		if !wrr.backends[b].IsAvailable() {
+	for i, id := range wrr.keys {
+		b := wrr.backends[id]
+		weight := b.Weight()
+		if weight <= 0 || !req.eligible(b) {
 			continue
 		}
 
 		// Increase current weight
-		wrr.currentWeights[i] += wrr.weights[i]
-		totalWeight += wrr.weights[i]
+		wrr.currentWeights[i] += weight
+		totalWeight += weight
 
 		// Pick the backend with highest current weight
 		if selectedIdx == -1 || wrr.currentWeights[i] > maxWeight {
@@ -88,15 +86,11 @@ func (wrr *weightedRoundRobin) AddBackend(id string, backend *backend.Backend) {
 	wrr.mu.Lock()
 	defer wrr.mu.Unlock()
 
-	weight := backend.Weight()
-	if weight > wrr.maxWeight {
-		wrr.maxWeight = weight
+	if _, exists := wrr.backends[id]; !exists {
+		wrr.keys = append(wrr.keys, id)
+		wrr.currentWeights = append(wrr.currentWeights, 0)
 	}
-
 	wrr.backends[id] = backend
-	wrr.keys = append(wrr.keys, id)
-	wrr.weights = append(wrr.weights, weight)
-	wrr.currentWeights = append(wrr.currentWeights, 0)
 }
 
 // RemoveBackend removes a backend from the balancer
@@ -106,26 +100,12 @@ func (wrr *weightedRoundRobin) RemoveBackend(id string) {
 
 	delete(wrr.backends, id)
 
-	// Remove from keys, weights, and currentWeights slices
+	// Remove from keys and currentWeights slices
 	for i, key := range wrr.keys {
 		if key == id {
 			wrr.keys = append(wrr.keys[:i], wrr.keys[i+1:]...)
-			wrr.weights = append(wrr.weights[:i], wrr.weights[i+1:]...)
 			wrr.currentWeights = append(wrr.currentWeights[:i], wrr.currentWeights[i+1:]...)
 			break
 		}
 	}
-
-	// Recalculate maxWeight
-	wrr.maxWeight = 0
-	for _, weight := range wrr.weights {
-		if weight > wrr.maxWeight {
-			wrr.maxWeight = weight
-		}
-	}
-
-	// Adjust current index if needed
-	if wrr.current >= len(wrr.keys) {
-		wrr.current = 0
-	}
 }
@@ -17,106 +17,356 @@ const (
 	HalfOpen
 )
 
+// String returns the name of the state
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// Mode selects how a closed circuit decides to open
+type Mode string
+
+const (
+	// ModeCount opens the circuit after consecutive failures
+	ModeCount Mode = "count"
+	// ModeWindow opens the circuit when the failure or slow call rate over a
+	// rolling time window crosses its threshold
+	ModeWindow Mode = "window"
+)
+
+// Default window mode settings
+const (
+	DefaultWindow               = 60 * time.Second
+	DefaultBuckets              = 10
+	DefaultMinimumRequests      = 20
+	DefaultFailureRateThreshold = 50
+)
+
 // Config represents the circuit breaker configuration
 type Config struct {
+	// Mode defaults to ModeCount
+	Mode             Mode
 	FailureThreshold int
 	ResetTimeout     time.Duration
 	HalfOpenLimit    int
+
+	// Window mode settings. Window is split into Buckets that expire one at
+	// a time. The circuit opens once the window holds MinimumRequests and
+	// the percentage of failed calls reaches FailureRateThreshold, or the
+	// percentage of calls taking SlowCallDuration or longer reaches
+	// SlowCallRateThreshold. Zero thresholds disable the check.
+	Window                time.Duration
+	Buckets               int
+	MinimumRequests       int
+	FailureRateThreshold  float64
+	SlowCallDuration      time.Duration
+	SlowCallRateThreshold float64
+}
+
+// DefaultConfig returns the default circuit breaker configuration
+func DefaultConfig() Config {
+	return Config{
+		Mode:                 ModeCount,
+		FailureThreshold:     5,
+		ResetTimeout:         30 * time.Second,
+		HalfOpenLimit:        3,
+		Window:               DefaultWindow,
+		Buckets:              DefaultBuckets,
+		MinimumRequests:      DefaultMinimumRequests,
+		FailureRateThreshold: DefaultFailureRateThreshold,
+	}
 }
 
-// CircuitBreaker implements the circuit breaker pattern
+// CircuitBreaker implements the circuit breaker pattern. Once open, the
+// circuit half-opens after the reset timeout and admits up to HalfOpenLimit
+// probe requests at a time; HalfOpenLimit successful probes close it and any
+// failed probe opens it again.
 type CircuitBreaker struct {
-	config          Config
-	state           State
+	config Config
+	state  State
+	// generation changes with every state change, so permits issued before
+	// a change don't count after it
+	generation      uint64
 	failureCount    int
 	successCount    int
+	inFlight        int
 	lastFailureTime time.Time
-	mu              sync.RWMutex
+	lastSuccessTime time.Time
+	// window counts recent requests in window mode
+	window *window
+	now    func() time.Time
+
+	onStateChange func(from, to State)
+	// changes are the state changes to report once the lock is released
+	changes []stateChange
+	mu      sync.RWMutex
+}
+
+// stateChange is a pending state change notification
+type stateChange struct {
+	from, to State
+}
+
+// Permit is a reservation to send a request through the circuit breaker. Its
+// outcome must be recorded with Record, or the permit given back with
+// Release, so half-open probe slots are not leaked. A permit must not be
+// used by more than one goroutine.
+type Permit struct {
+	cb         *CircuitBreaker
+	generation uint64
+	probe      bool
+	done       bool
 }
 
 // New creates a new circuit breaker
 func New(config Config) *CircuitBreaker {
-	return &CircuitBreaker{
-		config: config,
-		state:  Closed,
+	cb := &CircuitBreaker{
+		state: Closed,
+		now:   time.Now,
 	}
+	cb.configure(config)
+	return cb
 }
 
 // SetConfig updates the circuit breaker configuration
 func (cb *CircuitBreaker) SetConfig(config Config) {
 	cb.mu.Lock()
-	defer cb.mu.Unlock()
+	defer cb.unlock()
+	cb.configure(config)
+}
+
+// SetOnStateChange sets a function called after every state change. It is
+// called without the circuit breaker's lock held, possibly concurrently.
+func (cb *CircuitBreaker) SetOnStateChange(fn func(from, to State)) {
+	cb.mu.Lock()
+	defer cb.unlock()
+	cb.onStateChange = fn
+}
+
+// configure applies a configuration, starting a new window in window mode.
+// The caller must hold cb.mu unless cb is not shared yet.
+func (cb *CircuitBreaker) configure(config Config) {
 	cb.config = config
+	cb.window = nil
+	if config.Mode == ModeWindow {
+		cb.window = newWindow(config.Window, config.Buckets)
+	}
+}
+
+// unlock releases cb.mu, then reports the state changes made while it was held
+func (cb *CircuitBreaker) unlock() {
+	changes, fn := cb.changes, cb.onStateChange
+	cb.changes = nil
+	cb.mu.Unlock()
+
+	if fn != nil {
+		for _, c := range changes {
+			fn(c.from, c.to)
+		}
+	}
+}
+
+// setState moves the circuit to a new state, starting a new generation. The
+// caller must hold cb.mu.
+func (cb *CircuitBreaker) setState(to State) {
+	from := cb.state
+	if from == to {
+		return
+	}
+
+	cb.state = to
+	cb.generation++
+	cb.successCount = 0
+	cb.inFlight = 0
+	switch to {
+	case Open:
+		cb.lastFailureTime = cb.now()
+	case Closed:
+		cb.failureCount = 0
+	}
+	if cb.window != nil {
+		cb.window.reset()
+	}
+	cb.changes = append(cb.changes, stateChange{from: from, to: to})
+}
+
+// halfOpenLimit returns the number of probes a half-open circuit needs
+func (cb *CircuitBreaker) halfOpenLimit() int {
+	return max(cb.config.HalfOpenLimit, 1)
+}
+
+// refresh half-opens an open circuit whose reset timeout has passed. The
+// caller must hold cb.mu.
+func (cb *CircuitBreaker) refresh() {
+	if cb.state == Open && cb.now().Sub(cb.lastFailureTime) > cb.config.ResetTimeout {
+		cb.setState(HalfOpen)
+	}
 }
 
-// AllowRequest checks if a request should be allowed
+// AllowRequest reports whether the circuit admits requests, half-opening it
+// once the reset timeout has passed. It does not reserve a half-open probe,
+// so it suits availability checks; requests are sent with a permit from
+// Acquire.
 func (cb *CircuitBreaker) AllowRequest() bool {
-	cb.mu.RLock()
-	defer cb.mu.RUnlock()
+	cb.mu.Lock()
+	defer cb.unlock()
 
+	cb.refresh()
 	switch cb.state {
 	case Closed:
 		return true
-	case Open:
-		// Check if we should transition to half-open
-		if time.Since(cb.lastFailureTime) > cb.config.ResetTimeout {
-			cb.mu.RUnlock()
-			cb.mu.Lock()
-			cb.state = HalfOpen
-			cb.mu.Unlock()
-			cb.mu.RLock()
-			return true
-		}
-		return false
 	case HalfOpen:
-		// Allow limited requests in half-open state
-		return cb.successCount < cb.config.HalfOpenLimit
+		return cb.inFlight+cb.successCount < cb.halfOpenLimit()
 	default:
 		return false
 	}
 }
 
-// RecordSuccess records a successful request
-func (cb *CircuitBreaker) RecordSuccess() {
+// Acquire reserves a permit to send a request. In half-open state only
+// HalfOpenLimit probes are admitted at a time, counting successful ones.
+func (cb *CircuitBreaker) Acquire() (*Permit, bool) {
 	cb.mu.Lock()
-	defer cb.mu.Unlock()
+	defer cb.unlock()
 
+	cb.refresh()
 	switch cb.state {
 	case Closed:
-		// Reset failure count on success
-		cb.failureCount = 0
+		return &Permit{cb: cb, generation: cb.generation}, true
 	case HalfOpen:
-		cb.successCount++
-		// If we've had enough successes, close the circuit
-		if cb.successCount >= cb.config.HalfOpenLimit {
-			cb.state = Closed
-			cb.failureCount = 0
-			cb.successCount = 0
+		if cb.inFlight+cb.successCount >= cb.halfOpenLimit() {
+			return nil, false
 		}
+		cb.inFlight++
+		return &Permit{cb: cb, generation: cb.generation, probe: true}, true
+	default:
+		return nil, false
+	}
+}
+
+// Record records the outcome of the permitted request and how long it took.
+// Outcomes of permits issued before the last state change are ignored. Only
+// the first Record or Release of a permit counts.
+func (p *Permit) Record(success bool, duration time.Duration) {
+	if p.done {
+		return
+	}
+	p.done = true
+
+	cb := p.cb
+	cb.mu.Lock()
+	defer cb.unlock()
+	if p.generation == cb.generation {
+		cb.record(success, duration, p.probe)
+	}
+}
+
+// Release gives back a permit whose request was not sent or whose outcome
+// says nothing about the backend
+func (p *Permit) Release() {
+	if p.done {
+		return
+	}
+	p.done = true
+
+	cb := p.cb
+	cb.mu.Lock()
+	defer cb.unlock()
+	if p.probe && p.generation == cb.generation {
+		cb.inFlight--
 	}
 }
 
-// RecordFailure records a failed request
+// RecordSuccess records a successful request sent without a permit
+func (cb *CircuitBreaker) RecordSuccess() {
+	cb.RecordCall(true, 0)
+}
+
+// RecordFailure records a failed request sent without a permit
 func (cb *CircuitBreaker) RecordFailure() {
+	cb.RecordCall(false, 0)
+}
+
+// RecordCall records the outcome of a request sent without a permit and how
+// long it took. The duration only matters in window mode with a slow call
+// duration set. Such requests are not probes, so in half-open state their
+// failures open the circuit but their successes don't close it.
+func (cb *CircuitBreaker) RecordCall(success bool, duration time.Duration) {
 	cb.mu.Lock()
-	defer cb.mu.Unlock()
+	defer cb.unlock()
+	cb.record(success, duration, false)
+}
 
-	cb.failureCount++
-	cb.lastFailureTime = time.Now()
+// record applies the outcome of a request to the state. The caller must hold
+// cb.mu.
+func (cb *CircuitBreaker) record(success bool, duration time.Duration, probe bool) {
+	now := cb.now()
+	slow := cb.window != nil && cb.config.SlowCallDuration > 0 && duration >= cb.config.SlowCallDuration
+	if success {
+		cb.lastSuccessTime = now
+	} else {
+		cb.failureCount++
+		cb.lastFailureTime = now
+	}
 
 	switch cb.state {
 	case Closed:
-		// If we've exceeded the failure threshold, open the circuit
-		if cb.failureCount >= cb.config.FailureThreshold {
-			cb.state = Open
+		if cb.window != nil {
+			cb.window.record(now, !success, slow)
+			if cb.windowExceeded(now) {
+				cb.setState(Open)
+			}
+		} else if success {
+			// Reset failure count on success
+			cb.failureCount = 0
+		} else if cb.failureCount >= cb.config.FailureThreshold {
+			// If we've exceeded the failure threshold, open the circuit
+			cb.setState(Open)
 		}
 	case HalfOpen:
-		// Any failure in half-open state opens the circuit
-		cb.state = Open
-		cb.successCount = 0
+		if probe {
+			cb.inFlight--
+		}
+		if !success || slow {
+			// Any failure or slow call in half-open state opens the circuit
+			cb.setState(Open)
+			return
+		}
+		if !probe {
+			return
+		}
+		cb.successCount++
+		// If we've had enough successful probes, close the circuit
+		if cb.successCount >= cb.halfOpenLimit() {
+			cb.setState(Closed)
+		}
 	}
 }
 
+// windowExceeded reports whether the failure or slow call rate over the
+// window has reached its threshold. The caller must hold cb.mu.
+func (cb *CircuitBreaker) windowExceeded(now time.Time) bool {
+	requests, failures, slow := cb.window.totals(now)
+	if requests == 0 || requests < cb.config.MinimumRequests {
+		return false
+	}
+	rate := func(n int) float64 {
+		return float64(n) * 100 / float64(requests)
+	}
+	if cb.config.FailureRateThreshold > 0 && rate(failures) >= cb.config.FailureRateThreshold {
+		return true
+	}
+	return cb.config.SlowCallDuration > 0 && cb.config.SlowCallRateThreshold > 0 &&
+		rate(slow) >= cb.config.SlowCallRateThreshold
+}
+
 // GetState returns the current state of the circuit breaker
 func (cb *CircuitBreaker) GetState() State {
 	cb.mu.RLock()
@@ -124,8 +374,15 @@ func (cb *CircuitBreaker) GetState() State {
 	return cb.state
 }
 
-// GetFailureCount returns the current failure count
+// GetFailureCount returns the current failure count: consecutive failures in
+// count mode, failures within the window in window mode
 func (cb *CircuitBreaker) GetFailureCount() int {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+	if cb.window != nil {
+		_, failures, _ := cb.window.totals(cb.now())
+		return failures
+	}
 	return cb.failureCount
 }
 
@@ -140,5 +397,5 @@ func (cb *CircuitBreaker) GetLastFailure() time.Time {
 func (cb *CircuitBreaker) GetLastSuccess() time.Time {
 	cb.mu.RLock()
 	defer cb.mu.RUnlock()
-	return cb.lastFailureTime
+	return cb.lastSuccessTime
 }
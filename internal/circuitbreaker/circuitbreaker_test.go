@@ -0,0 +1,371 @@
+package circuitbreaker
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestBreaker creates a circuit breaker with a controllable clock
+func newTestBreaker(config Config) (*CircuitBreaker, *time.Time) {
+	cb := New(config)
+	now := time.Unix(1000, 0)
+	cb.now = func() time.Time { return now }
+	return cb, &now
+}
+
+func windowConfig() Config {
+	return Config{
+		Mode:                 ModeWindow,
+		ResetTimeout:         30 * time.Second,
+		HalfOpenLimit:        1,
+		Window:               10 * time.Second,
+		Buckets:              10,
+		MinimumRequests:      10,
+		FailureRateThreshold: 40,
+	}
+}
+
+func TestCountModeIgnoresFailureRate(t *testing.T) {
+	cb := New(Config{FailureThreshold: 3, ResetTimeout: time.Minute, HalfOpenLimit: 1})
+
+	// 40% of requests fail, but never three in a row
+	for i := 0; i < 50; i++ {
+		if i%5 < 2 {
+			cb.RecordFailure()
+		} else {
+			cb.RecordSuccess()
+		}
+	}
+	if cb.GetState() != Closed {
+		t.Errorf("State = %v, want Closed", cb.GetState())
+	}
+}
+
+func TestWindowFailureRate(t *testing.T) {
+	cb, _ := newTestBreaker(windowConfig())
+
+	// Failures below the minimum request volume don't open the circuit
+	for i := 0; i < 9; i++ {
+		if i%5 < 2 {
+			cb.RecordFailure()
+		} else {
+			cb.RecordSuccess()
+		}
+	}
+	if cb.GetState() != Closed {
+		t.Fatalf("State = %v before minimum requests, want Closed", cb.GetState())
+	}
+	if got := cb.GetFailureCount(); got != 4 {
+		t.Errorf("Failure count = %d, want 4", got)
+	}
+
+	// The tenth request brings the failure rate to 40%
+	cb.RecordSuccess()
+	if cb.GetState() != Open {
+		t.Fatalf("State = %v at 40%% failures, want Open", cb.GetState())
+	}
+	if cb.AllowRequest() {
+		t.Error("Expected open circuit to block requests")
+	}
+}
+
+func TestWindowExpiresOldBuckets(t *testing.T) {
+	cb, now := newTestBreaker(windowConfig())
+
+	for i := 0; i < 5; i++ {
+		cb.RecordFailure()
+	}
+	*now = now.Add(5 * time.Second)
+	for i := 0; i < 4; i++ {
+		cb.RecordSuccess()
+	}
+	if got := cb.GetFailureCount(); got != 5 {
+		t.Errorf("Failure count = %d, want 5", got)
+	}
+
+	// Once the failures leave the window, the rate is computed without them
+	*now = now.Add(5 * time.Second)
+	if got := cb.GetFailureCount(); got != 0 {
+		t.Errorf("Failure count = %d after expiry, want 0", got)
+	}
+	for i := 0; i < 6; i++ {
+		cb.RecordSuccess()
+	}
+	cb.RecordFailure()
+	if cb.GetState() != Closed {
+		t.Errorf("State = %v, want Closed", cb.GetState())
+	}
+}
+
+func TestWindowSlowCallRate(t *testing.T) {
+	config := windowConfig()
+	config.FailureRateThreshold = 0
+	config.SlowCallDuration = 100 * time.Millisecond
+	config.SlowCallRateThreshold = 50
+	cb, now := newTestBreaker(config)
+
+	for i := 0; i < 10; i++ {
+		latency := 10 * time.Millisecond
+		if i%2 == 0 {
+			latency = 200 * time.Millisecond
+		}
+		cb.RecordCall(true, latency)
+	}
+	if cb.GetState() != Open {
+		t.Fatalf("State = %v at 50%% slow calls, want Open", cb.GetState())
+	}
+
+	// A slow probe in half-open state reopens the circuit
+	*now = now.Add(31 * time.Second)
+	permit, ok := cb.Acquire()
+	if !ok || cb.GetState() != HalfOpen {
+		t.Fatalf("State = %v after reset timeout, want HalfOpen", cb.GetState())
+	}
+	permit.Record(true, 200*time.Millisecond)
+	if cb.GetState() != Open {
+		t.Fatalf("State = %v after slow half-open call, want Open", cb.GetState())
+	}
+
+	// A fast probe closes it
+	*now = now.Add(31 * time.Second)
+	permit, _ = cb.Acquire()
+	permit.Record(true, 10*time.Millisecond)
+	if cb.GetState() != Closed {
+		t.Errorf("State = %v after fast half-open call, want Closed", cb.GetState())
+	}
+}
+
+// openBreaker creates a count mode breaker that has just opened
+func openBreaker(t *testing.T, halfOpenLimit int) (*CircuitBreaker, *time.Time) {
+	t.Helper()
+	cb, now := newTestBreaker(Config{FailureThreshold: 1, ResetTimeout: 30 * time.Second, HalfOpenLimit: halfOpenLimit})
+	cb.RecordFailure()
+	if cb.GetState() != Open {
+		t.Fatalf("State = %v after failure, want Open", cb.GetState())
+	}
+	return cb, now
+}
+
+func TestHalfOpenPermits(t *testing.T) {
+	cb, now := openBreaker(t, 2)
+	if _, ok := cb.Acquire(); ok {
+		t.Fatal("Expected open circuit to refuse permits")
+	}
+	*now = now.Add(31 * time.Second)
+
+	// Availability checks don't use up probes
+	for i := 0; i < 5; i++ {
+		if !cb.AllowRequest() {
+			t.Fatal("Expected half-open circuit to allow requests")
+		}
+	}
+
+	first, ok := cb.Acquire()
+	if !ok {
+		t.Fatal("Expected first probe permit")
+	}
+	second, ok := cb.Acquire()
+	if !ok {
+		t.Fatal("Expected second probe permit")
+	}
+	if _, ok := cb.Acquire(); ok {
+		t.Fatal("Expected probe limit to refuse a third permit")
+	}
+	if cb.AllowRequest() {
+		t.Error("Expected AllowRequest to be false with all probes in flight")
+	}
+
+	// A released probe frees its slot, once
+	second.Release()
+	second.Release()
+	third, ok := cb.Acquire()
+	if !ok {
+		t.Fatal("Expected permit after release")
+	}
+	if _, ok := cb.Acquire(); ok {
+		t.Fatal("Expected double release not to free another slot")
+	}
+
+	// Successes of every probe are needed to close the circuit
+	first.Record(true, 0)
+	if cb.GetState() != HalfOpen {
+		t.Fatalf("State = %v after one probe, want HalfOpen", cb.GetState())
+	}
+	third.Record(true, 0)
+	if cb.GetState() != Closed {
+		t.Fatalf("State = %v after all probes, want Closed", cb.GetState())
+	}
+}
+
+func TestHalfOpenFailedProbe(t *testing.T) {
+	cb, now := openBreaker(t, 2)
+	*now = now.Add(31 * time.Second)
+
+	failed, _ := cb.Acquire()
+	stale, _ := cb.Acquire()
+	failed.Record(false, 0)
+	if cb.GetState() != Open {
+		t.Fatalf("State = %v after failed probe, want Open", cb.GetState())
+	}
+
+	// Probes of the previous half-open phase don't count in the next one
+	*now = now.Add(31 * time.Second)
+	probe, ok := cb.Acquire()
+	if !ok {
+		t.Fatal("Expected probe permit after reset timeout")
+	}
+	stale.Record(true, 0)
+	stale.Release()
+	if _, ok := cb.Acquire(); !ok {
+		t.Fatal("Expected stale permit not to take a probe slot")
+	}
+	if cb.GetState() != HalfOpen {
+		t.Fatalf("State = %v after stale success, want HalfOpen", cb.GetState())
+	}
+	probe.Record(false, 0)
+	if cb.GetState() != Open {
+		t.Errorf("State = %v after failed probe, want Open", cb.GetState())
+	}
+}
+
+func TestHalfOpenIgnoresUnpermittedSuccess(t *testing.T) {
+	cb, now := openBreaker(t, 1)
+	*now = now.Add(31 * time.Second)
+	if !cb.AllowRequest() {
+		t.Fatal("Expected half-open circuit to allow requests")
+	}
+
+	cb.RecordSuccess()
+	if cb.GetState() != HalfOpen {
+		t.Fatalf("State = %v after success without permit, want HalfOpen", cb.GetState())
+	}
+	cb.RecordFailure()
+	if cb.GetState() != Open {
+		t.Errorf("State = %v after failure without permit, want Open", cb.GetState())
+	}
+}
+
+func TestStateChangeCallback(t *testing.T) {
+	cb, now := newTestBreaker(Config{FailureThreshold: 2, ResetTimeout: 30 * time.Second, HalfOpenLimit: 1})
+
+	type change struct{ from, to State }
+	var changes []change
+	cb.SetOnStateChange(func(from, to State) {
+		// The callback runs without the lock held
+		if got := cb.GetState(); got != to {
+			t.Errorf("GetState() = %v in callback, want %v", got, to)
+		}
+		changes = append(changes, change{from, to})
+	})
+
+	cb.RecordFailure()
+	cb.RecordFailure()
+	*now = now.Add(31 * time.Second)
+	probe, _ := cb.Acquire()
+	probe.Record(false, 0)
+	*now = now.Add(31 * time.Second)
+	probe, _ = cb.Acquire()
+	probe.Record(true, 0)
+	cb.RecordSuccess()
+
+	want := []change{
+		{Closed, Open},
+		{Open, HalfOpen},
+		{HalfOpen, Open},
+		{Open, HalfOpen},
+		{HalfOpen, Closed},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("Got %d state changes %v, want %v", len(changes), changes, want)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("Change %d = %v, want %v", i, changes[i], want[i])
+		}
+	}
+}
+
+func TestConcurrentStress(t *testing.T) {
+	const limit = 3
+	config := Config{FailureThreshold: 2, ResetTimeout: time.Millisecond, HalfOpenLimit: limit}
+	cb := New(config)
+
+	var mu sync.Mutex
+	var transitions int
+	cb.SetOnStateChange(func(from, to State) {
+		if from == to {
+			t.Errorf("Reported change from %v to itself", from)
+		}
+		mu.Lock()
+		transitions++
+		mu.Unlock()
+	})
+
+	// checkProbes fails if more probes are out than the half-open limit
+	checkProbes := func() {
+		cb.mu.RLock()
+		defer cb.mu.RUnlock()
+		if cb.inFlight < 0 || cb.inFlight+cb.successCount > limit {
+			t.Errorf("%d probes in flight and %d succeeded, limit %d", cb.inFlight, cb.successCount, limit)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				switch (g + i) % 7 {
+				case 0:
+					cb.AllowRequest()
+				case 1:
+					cb.GetState()
+					cb.GetFailureCount()
+				case 2:
+					if g == 0 && i%100 == 2 {
+						cb.SetConfig(config)
+					} else {
+						cb.RecordCall(i%3 != 0, 0)
+					}
+				default:
+					permit, ok := cb.Acquire()
+					if !ok {
+						continue
+					}
+					checkProbes()
+					switch i % 4 {
+					case 0:
+						permit.Record(false, 0)
+					case 1:
+						permit.Release()
+					default:
+						permit.Record(true, time.Millisecond)
+					}
+					// Settling a permit again has no effect
+					permit.Record(false, 0)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	checkProbes()
+
+	if transitions == 0 {
+		t.Error("Expected state changes under load")
+	}
+
+	// Every outstanding permit was settled, so the circuit can still recover
+	time.Sleep(2 * time.Millisecond)
+	for i := 0; i < limit; i++ {
+		permit, ok := cb.Acquire()
+		if !ok {
+			t.Fatalf("Probe %d refused in state %v", i, cb.GetState())
+		}
+		permit.Record(true, 0)
+	}
+	if cb.GetState() != Closed {
+		t.Errorf("State = %v after successful probes, want Closed", cb.GetState())
+	}
+}
@@ -0,0 +1,66 @@
+package circuitbreaker
+
+import "time"
+
+// bucket counts the requests of one slice of the window
+type bucket struct {
+	// epoch is the index of the time slice the counts belong to
+	epoch    int64
+	requests int
+	failures int
+	slow     int
+}
+
+// window counts requests over a rolling time window split into buckets.
+// Buckets are reused as time moves on, so old requests expire a bucket at a
+// time.
+type window struct {
+	buckets []bucket
+	width   time.Duration
+}
+
+// newWindow creates a window of the given length split into n buckets
+func newWindow(length time.Duration, n int) *window {
+	n = max(n, 1)
+	if length <= 0 {
+		length = DefaultWindow
+	}
+	return &window{
+		buckets: make([]bucket, n),
+		width:   max(length/time.Duration(n), 1),
+	}
+}
+
+// record adds a request at the given time
+func (w *window) record(now time.Time, failed, slow bool) {
+	epoch := now.UnixNano() / int64(w.width)
+	b := &w.buckets[epoch%int64(len(w.buckets))]
+	if b.epoch != epoch {
+		*b = bucket{epoch: epoch}
+	}
+	b.requests++
+	if failed {
+		b.failures++
+	}
+	if slow {
+		b.slow++
+	}
+}
+
+// totals returns the counts of the buckets still inside the window
+func (w *window) totals(now time.Time) (requests, failures, slow int) {
+	epoch := now.UnixNano() / int64(w.width)
+	for _, b := range w.buckets {
+		if b.requests > 0 && b.epoch > epoch-int64(len(w.buckets)) && b.epoch <= epoch {
+			requests += b.requests
+			failures += b.failures
+			slow += b.slow
+		}
+	}
+	return requests, failures, slow
+}
+
+// reset clears all buckets
+func (w *window) reset() {
+	clear(w.buckets)
+}
@@ -2,15 +2,41 @@ package config
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"regexp"
+	"strconv"
 	"time"
 
+	"load-balancer/internal/backend"
+	"load-balancer/internal/balancer"
+	"load-balancer/internal/circuitbreaker"
+	"load-balancer/internal/health"
+	"load-balancer/internal/outlier"
+	"load-balancer/internal/proxy"
+	"load-balancer/internal/retry"
 	"load-balancer/internal/session"
+	"load-balancer/internal/tcpproxy"
+	"load-balancer/internal/udpproxy"
 	tlsmanager "load-balancer/pkg/tls"
 )
 
+// Listener modes
+const (
+	// ModeHTTP proxies HTTP requests
+	ModeHTTP = "http"
+	// ModeTCP proxies raw TCP connections
+	ModeTCP = "tcp"
+	// ModeUDP forwards UDP datagrams
+	ModeUDP = "udp"
+)
+
 // Duration is a custom type for time.Duration that supports JSON unmarshaling
 type Duration time.Duration
 
@@ -47,13 +73,33 @@ type TLSConfig struct {
 // Config represents the load balancer configuration
 type Config struct {
 	Server struct {
+		// Mode selects the listener: "http" (default), "tcp" or "udp"
+		Mode string    `json:"mode"`
 		Port int       `json:"port"`
 		TLS  TLSConfig `json:"tls"`
+		// TrustedProxies lists the CIDRs whose X-Forwarded-* and Forwarded
+		// headers are kept; other clients have them replaced
+		TrustedProxies []string `json:"trusted_proxies"`
+		// H2C accepts cleartext HTTP/2 alongside HTTP/1.1
+		H2C bool `json:"h2c"`
 	} `json:"server"`
 
 	// Load balancer configuration
 	Algorithm string `json:"algorithm"`
 
+	// Consistent hash configuration
+	Hash struct {
+		Key          string  `json:"key"`
+		Name         string  `json:"name"`
+		VirtualNodes int     `json:"virtual_nodes"`
+		LoadFactor   float64 `json:"load_factor"`
+	} `json:"hash"`
+
+	// Peak EWMA configuration
+	EWMA struct {
+		DecayTime Duration `json:"decay_time"`
+	} `json:"ewma"`
+
 	// Sticky session configuration
 	StickySession struct {
 		Enabled         bool     `json:"enabled"`
@@ -65,19 +111,41 @@ type Config struct {
 	} `json:"sticky_session"`
 
 	// Health check configuration
-	HealthCheck struct {
-		Interval Duration `json:"interval"`
-		Timeout  Duration `json:"timeout"`
-		Path     string   `json:"path"`
-	} `json:"health_check"`
+	HealthCheck HealthCheckConfig `json:"health_check"`
 
 	// Circuit breaker configuration
 	CircuitBreaker struct {
+		// Mode is "count" (consecutive failures) or "window" (failure and
+		// slow call rates over a rolling window)
+		Mode             string   `json:"mode"`
 		FailureThreshold int      `json:"failure_threshold"`
 		ResetTimeout     Duration `json:"reset_timeout"`
 		HalfOpenLimit    int      `json:"half_open_limit"`
+
+		// Window mode settings; rates are percentages of the requests in the window
+		Window                Duration `json:"window"`
+		Buckets               int      `json:"buckets"`
+		MinimumRequests       int      `json:"minimum_requests"`
+		FailureRateThreshold  float64  `json:"failure_rate_threshold"`
+		SlowCallDuration      Duration `json:"slow_call_duration"`
+		SlowCallRateThreshold float64  `json:"slow_call_rate_threshold"`
 	} `json:"circuit_breaker"`
 
+	// Outlier detection configuration
+	OutlierDetection struct {
+		Enabled                  bool     `json:"enabled"`
+		Interval                 Duration `json:"interval"`
+		Consecutive5xx           int      `json:"consecutive_5xx"`
+		ConsecutiveGatewayErrors int      `json:"consecutive_gateway_errors"`
+		BaseEjectionTime         Duration `json:"base_ejection_time"`
+		MaxEjectionTime          Duration `json:"max_ejection_time"`
+		MaxEjectionPercent       int      `json:"max_ejection_percent"`
+		SuccessRateMinHosts      int      `json:"success_rate_minimum_hosts"`
+		SuccessRateRequestVolume int      `json:"success_rate_request_volume"`
+		SuccessRateStdevFactor   float64  `json:"success_rate_stdev_factor"`
+		LatencyFactor            float64  `json:"latency_factor"`
+	} `json:"outlier_detection"`
+
 	// Retry configuration
 	Retry struct {
 		MaxRetries      int      `json:"max_retries"`
@@ -85,8 +153,51 @@ type Config struct {
 		MaxInterval     Duration `json:"max_interval"`
 		Multiplier      float64  `json:"multiplier"`
 		Randomization   float64  `json:"randomization"`
+		MaxElapsedTime  Duration `json:"max_elapsed_time"`
+		// Policy names the retry policy: default, connect-failure or none
+		Policy string `json:"policy"`
+		// StatusCodes overrides the response status codes retried by the policy
+		StatusCodes []int `json:"status_codes"`
+		// Budget limits retries to a fraction of recent requests
+		Budget struct {
+			Enabled             bool     `json:"enabled"`
+			Ratio               float64  `json:"ratio"`
+			MinRetriesPerSecond int      `json:"min_retries_per_second"`
+			TTL                 Duration `json:"ttl"`
+		} `json:"budget"`
+		// MaxBodyBuffer is the request body size in bytes kept in memory for
+		// retries; larger bodies are buffered in a temporary file
+		MaxBodyBuffer int64 `json:"max_body_buffer"`
+		// MaxBodySpill is the largest request body in bytes buffered for
+		// retries; larger requests are sent once, without retries
+		MaxBodySpill int64 `json:"max_body_spill"`
 	} `json:"retry"`
 
+	// Default proxy settings for requests no route matches
+	Proxy struct {
+		FlushInterval Duration `json:"flush_interval"`
+		Timeout       Duration `json:"timeout"`
+		IdleTimeout   Duration `json:"idle_timeout"`
+	} `json:"proxy"`
+
+	// TCP mode configuration
+	TCP struct {
+		DialTimeout Duration `json:"dial_timeout"`
+		IdleTimeout Duration `json:"idle_timeout"`
+		// DrainTimeout is how long shutdown waits for open connections
+		DrainTimeout Duration `json:"drain_timeout"`
+	} `json:"tcp"`
+
+	// UDP mode configuration
+	UDP struct {
+		IdleTimeout Duration `json:"idle_timeout"`
+		// AffinityTTL keeps a client IP on the same backend after its flows expire
+		AffinityTTL Duration `json:"affinity_ttl"`
+	} `json:"udp"`
+
+	// Per-path route configuration
+	Routes []RouteConfig `json:"routes"`
+
 	// Backend configuration
 	Backends []BackendConfig `json:"backends"`
 }
@@ -96,6 +207,91 @@ type BackendConfig struct {
 	ID     string `json:"id"`
 	URL    string `json:"url"`
 	Weight int    `json:"weight"`
+	// RewriteHost sends the backend's host as the Host header instead of the client's
+	RewriteHost bool `json:"rewrite_host"`
+	// Protocol selects how requests reach the backend: "http1", "h2" (HTTP/2
+	// over TLS), "h2c" (cleartext HTTP/2 with prior knowledge) or empty to negotiate
+	Protocol string `json:"protocol"`
+	// HealthCheck overrides the global health check settings for this backend
+	HealthCheck *HealthCheckConfig `json:"health_check"`
+	// AgentCheck polls an agent on the backend that reports its weight and state
+	AgentCheck *AgentCheckConfig `json:"agent_check"`
+}
+
+// AgentCheckConfig represents the settings of a backend's agent check
+type AgentCheckConfig struct {
+	// Address is the agent's host:port; it defaults to the backend's host
+	// with Port
+	Address string `json:"address"`
+	Port    int    `json:"port"`
+	// Send is written to the agent before reading its response
+	Send string `json:"send"`
+	// Timeout defaults to the health check timeout
+	Timeout Duration `json:"timeout"`
+}
+
+// HealthCheckConfig represents health check settings. Settings on a backend
+// override the global ones.
+type HealthCheckConfig struct {
+	// Type selects the probe: "http", "tcp", "udp", "grpc", "exec" or
+	// "composite"; it defaults to the listener mode
+	Type string `json:"type"`
+	// Interval, Rise, Fall and InitialState are only read from the global settings
+	Interval Duration `json:"interval"`
+	// Rise and Fall are the consecutive successful and failed checks needed
+	// to mark a backend healthy or unhealthy
+	Rise int `json:"rise"`
+	Fall int `json:"fall"`
+	// InitialState is the health of backends before their first checks: "up" or "down"
+	InitialState string `json:"initial_state"`
+
+	Timeout Duration `json:"timeout"`
+	// URL probes another address than the backend's, e.g. an admin listener
+	URL string `json:"url"`
+	// Port probes a port other than the backend's (http, tcp and grpc)
+	Port int    `json:"port"`
+	Path string `json:"path"`
+
+	// HTTP request and response assertions
+	Method  string            `json:"method"`
+	Headers map[string]string `json:"headers"`
+	// StatusCodes lists acceptable codes or ranges, e.g. ["200-299", "301"]
+	StatusCodes  []string `json:"status_codes"`
+	BodyContains string   `json:"body_contains"`
+	BodyRegex    string   `json:"body_regex"`
+	// JSONPath is a dot-separated path into a JSON body whose value must equal JSONValue
+	JSONPath  string `json:"json_path"`
+	JSONValue string `json:"json_value"`
+	// TLSSkipVerify and CAFile configure verification of HTTPS and gRPC TLS backends
+	TLSSkipVerify bool   `json:"tls_skip_verify"`
+	CAFile        string `json:"ca_file"`
+
+	// Send is written after connecting and Expect must appear in the reply
+	// (tcp and udp)
+	Send   string `json:"send"`
+	Expect string `json:"expect"`
+
+	// Service is the gRPC service checked; empty checks the whole server
+	Service string `json:"service"`
+
+	// Command is the program and arguments run by exec checks
+	Command []string `json:"command"`
+
+	// Checks are combined by composite checks, which pass when "all" (the
+	// default) or "any" of them pass according to Mode
+	Mode   string              `json:"mode"`
+	Checks []HealthCheckConfig `json:"checks"`
+}
+
+// RouteConfig represents the settings for requests matching a path prefix
+type RouteConfig struct {
+	PathPrefix         string `json:"path_prefix"`
+	RetryNonIdempotent bool   `json:"retry_non_idempotent"`
+	// FlushInterval is how often streamed responses are flushed; negative
+	// flushes after every write
+	FlushInterval Duration `json:"flush_interval"`
+	Timeout       Duration `json:"timeout"`
+	IdleTimeout   Duration `json:"idle_timeout"`
 }
 
 // Load loads the configuration from a file
@@ -112,6 +308,10 @@ func Load(path string) (*Config, error) {
 	}
 
 	// Set default values if not specified
+	if config.Server.Mode == "" {
+		config.Server.Mode = ModeHTTP
+	}
+
 	if config.Server.Port == 0 {
 		config.Server.Port = 8080
 	}
@@ -124,10 +324,115 @@ func Load(path string) (*Config, error) {
 		config.HealthCheck.Timeout = Duration(2 * time.Second)
 	}
 
+	if config.HealthCheck.Type == "" {
+		config.HealthCheck.Type = config.Server.Mode
+	}
+
+	if config.HealthCheck.Rise == 0 {
+		config.HealthCheck.Rise = 2
+	}
+
+	if config.HealthCheck.Fall == 0 {
+		config.HealthCheck.Fall = 3
+	}
+
+	if config.HealthCheck.InitialState == "" {
+		config.HealthCheck.InitialState = "up"
+	}
+
 	if config.HealthCheck.Path == "" {
 		config.HealthCheck.Path = "/health"
 	}
 
+	// Set default outlier detection configuration
+	defaultOutlier := outlier.DefaultConfig()
+	if config.OutlierDetection.Interval == 0 {
+		config.OutlierDetection.Interval = Duration(defaultOutlier.Interval)
+	}
+	if config.OutlierDetection.Consecutive5xx == 0 {
+		config.OutlierDetection.Consecutive5xx = defaultOutlier.Consecutive5xx
+	}
+	if config.OutlierDetection.BaseEjectionTime == 0 {
+		config.OutlierDetection.BaseEjectionTime = Duration(defaultOutlier.BaseEjectionTime)
+	}
+	if config.OutlierDetection.MaxEjectionTime == 0 {
+		config.OutlierDetection.MaxEjectionTime = Duration(defaultOutlier.MaxEjectionTime)
+	}
+	if config.OutlierDetection.MaxEjectionPercent == 0 {
+		config.OutlierDetection.MaxEjectionPercent = defaultOutlier.MaxEjectionPercent
+	}
+	if config.OutlierDetection.SuccessRateMinHosts == 0 {
+		config.OutlierDetection.SuccessRateMinHosts = defaultOutlier.SuccessRateMinHosts
+	}
+	if config.OutlierDetection.SuccessRateRequestVolume == 0 {
+		config.OutlierDetection.SuccessRateRequestVolume = defaultOutlier.SuccessRateRequestVolume
+	}
+	if config.OutlierDetection.SuccessRateStdevFactor == 0 {
+		config.OutlierDetection.SuccessRateStdevFactor = defaultOutlier.SuccessRateStdevFactor
+	}
+
+	// Set default circuit breaker window configuration
+	defaultBreaker := circuitbreaker.DefaultConfig()
+	if config.CircuitBreaker.Mode == "" {
+		config.CircuitBreaker.Mode = string(defaultBreaker.Mode)
+	}
+	if config.CircuitBreaker.Window == 0 {
+		config.CircuitBreaker.Window = Duration(defaultBreaker.Window)
+	}
+	if config.CircuitBreaker.Buckets == 0 {
+		config.CircuitBreaker.Buckets = defaultBreaker.Buckets
+	}
+	if config.CircuitBreaker.MinimumRequests == 0 {
+		config.CircuitBreaker.MinimumRequests = defaultBreaker.MinimumRequests
+	}
+	if config.CircuitBreaker.FailureRateThreshold == 0 {
+		config.CircuitBreaker.FailureRateThreshold = defaultBreaker.FailureRateThreshold
+	}
+
+	if config.Retry.Policy == "" {
+		config.Retry.Policy = retry.DefaultPolicyName
+	}
+
+	// Set default retry budget configuration
+	defaultBudget := retry.DefaultBudgetConfig()
+	if config.Retry.Budget.Ratio == 0 {
+		config.Retry.Budget.Ratio = defaultBudget.Ratio
+	}
+	if config.Retry.Budget.MinRetriesPerSecond == 0 {
+		config.Retry.Budget.MinRetriesPerSecond = defaultBudget.MinRetriesPerSecond
+	}
+	if config.Retry.Budget.TTL == 0 {
+		config.Retry.Budget.TTL = Duration(defaultBudget.TTL)
+	}
+
+	if config.Retry.MaxBodyBuffer == 0 {
+		config.Retry.MaxBodyBuffer = proxy.DefaultMaxBodyBuffer
+	}
+	if config.Retry.MaxBodySpill == 0 {
+		config.Retry.MaxBodySpill = proxy.DefaultMaxBodySpill
+	}
+
+	if config.Proxy.IdleTimeout == 0 {
+		config.Proxy.IdleTimeout = Duration(proxy.DefaultIdleTimeout)
+	}
+
+	// Set default TCP mode configuration
+	defaultTCP := tcpproxy.DefaultConfig()
+	if config.TCP.DialTimeout == 0 {
+		config.TCP.DialTimeout = Duration(defaultTCP.DialTimeout)
+	}
+	if config.TCP.IdleTimeout == 0 {
+		config.TCP.IdleTimeout = Duration(defaultTCP.IdleTimeout)
+	}
+	if config.TCP.DrainTimeout == 0 {
+		config.TCP.DrainTimeout = Duration(30 * time.Second)
+	}
+
+	// Set default UDP mode configuration
+	if config.UDP.IdleTimeout == 0 {
+		config.UDP.IdleTimeout = Duration(udpproxy.DefaultIdleTimeout)
+	}
+
 	if config.Algorithm == "" {
 		config.Algorithm = "round-robin"
 	}
@@ -159,9 +464,49 @@ func Load(path string) (*Config, error) {
 		config.StickySession.CleanupInterval = Duration(1 * time.Hour)
 	}
 
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
 	return &config, nil
 }
 
+// validate checks settings that would otherwise be silently ignored
+func (c *Config) validate() error {
+	for _, b := range c.Backends {
+		switch backend.Protocol(b.Protocol) {
+		case backend.ProtocolAuto, backend.ProtocolHTTP1, backend.ProtocolHTTP2, backend.ProtocolH2C:
+		default:
+			return fmt.Errorf("backend %s: unknown protocol %q", b.ID, b.Protocol)
+		}
+		if err := b.AgentCheck.validate(); err != nil {
+			return fmt.Errorf("backend %s: agent check: %w", b.ID, err)
+		}
+	}
+	return nil
+}
+
+// validate checks that the agent check has a port to poll
+func (ac *AgentCheckConfig) validate() error {
+	if ac == nil {
+		return nil
+	}
+	port := ac.Port
+	if ac.Address != "" {
+		_, p, err := net.SplitHostPort(ac.Address)
+		if err != nil {
+			return err
+		}
+		if port, err = strconv.Atoi(p); err != nil {
+			return fmt.Errorf("invalid port %q", p)
+		}
+	}
+	if port <= 0 || port > 65535 {
+		return fmt.Errorf("invalid or missing port %d", port)
+	}
+	return nil
+}
+
 // Save saves the configuration to a file
 func (c *Config) Save(path string) error {
 	file, err := os.Create(path)
@@ -206,6 +551,307 @@ func (c *Config) GetTLSConfig() (*tlsmanager.Config, error) {
 	}, nil
 }
 
+// GetBalancerConfig converts the algorithm settings to a balancer.Config
+func (c *Config) GetBalancerConfig() balancer.Config {
+	return balancer.Config{
+		Algorithm: c.Algorithm,
+		Hash: balancer.HashConfig{
+			Key:          balancer.HashKeySource(c.Hash.Key),
+			Name:         c.Hash.Name,
+			VirtualNodes: c.Hash.VirtualNodes,
+			LoadFactor:   c.Hash.LoadFactor,
+		},
+		EWMA: balancer.EWMAConfig{
+			DecayTime: time.Duration(c.EWMA.DecayTime),
+		},
+	}
+}
+
+// GetRetryConfig converts the retry configuration to a retry.Config
+func (c *Config) GetRetryConfig() (*retry.Config, error) {
+	policy, err := retry.PolicyByName(c.Retry.Policy)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Retry.StatusCodes) > 0 {
+		policy.StatusCodes = c.Retry.StatusCodes
+	}
+
+	var budget *retry.Budget
+	if c.Retry.Budget.Enabled {
+		budget = retry.NewBudget(retry.BudgetConfig{
+			Ratio:               c.Retry.Budget.Ratio,
+			MinRetriesPerSecond: c.Retry.Budget.MinRetriesPerSecond,
+			TTL:                 time.Duration(c.Retry.Budget.TTL),
+		})
+	}
+
+	return &retry.Config{
+		MaxRetries:      c.Retry.MaxRetries,
+		InitialInterval: time.Duration(c.Retry.InitialInterval),
+		MaxInterval:     time.Duration(c.Retry.MaxInterval),
+		Multiplier:      c.Retry.Multiplier,
+		Randomization:   c.Retry.Randomization,
+		MaxElapsedTime:  time.Duration(c.Retry.MaxElapsedTime),
+		Policy:          policy,
+		Budget:          budget,
+	}, nil
+}
+
+// GetRoutes converts the route configuration to proxy routes
+func (c *Config) GetRoutes() []proxy.Route {
+	routes := make([]proxy.Route, len(c.Routes))
+	for i, route := range c.Routes {
+		routes[i] = proxy.Route{
+			PathPrefix:         route.PathPrefix,
+			RetryNonIdempotent: route.RetryNonIdempotent,
+			FlushInterval:      time.Duration(route.FlushInterval),
+			Timeout:            time.Duration(route.Timeout),
+			IdleTimeout:        time.Duration(route.IdleTimeout),
+		}
+	}
+	return routes
+}
+
+// GetDefaultRoute converts the default proxy settings to a proxy route
+func (c *Config) GetDefaultRoute() proxy.Route {
+	return proxy.Route{
+		FlushInterval: time.Duration(c.Proxy.FlushInterval),
+		Timeout:       time.Duration(c.Proxy.Timeout),
+		IdleTimeout:   time.Duration(c.Proxy.IdleTimeout),
+	}
+}
+
+// GetTCPConfig converts the TCP mode configuration to a tcpproxy.Config
+func (c *Config) GetTCPConfig() tcpproxy.Config {
+	return tcpproxy.Config{
+		DialTimeout: time.Duration(c.TCP.DialTimeout),
+		IdleTimeout: time.Duration(c.TCP.IdleTimeout),
+	}
+}
+
+// GetUDPConfig converts the UDP mode configuration to a udpproxy.Config
+func (c *Config) GetUDPConfig() udpproxy.Config {
+	return udpproxy.Config{
+		IdleTimeout: time.Duration(c.UDP.IdleTimeout),
+		AffinityTTL: time.Duration(c.UDP.AffinityTTL),
+	}
+}
+
+// GetHealthCheckConfig returns the health check configuration for a backend,
+// with the backend's settings overriding the global ones
+func (c *Config) GetHealthCheckConfig(b BackendConfig) (health.Config, error) {
+	hc := c.HealthCheck
+	hc.URL = b.URL
+	if o := b.HealthCheck; o != nil {
+		hc = hc.merge(*o)
+	}
+	return hc.healthConfig()
+}
+
+// GetAgentConfig returns the agent check configuration for a backend, and
+// false if the backend has no agent check
+func (c *Config) GetAgentConfig(b BackendConfig) (health.AgentConfig, bool) {
+	ac := b.AgentCheck
+	if ac == nil {
+		return health.AgentConfig{}, false
+	}
+
+	address := ac.Address
+	if address == "" {
+		if u, err := url.Parse(b.URL); err == nil {
+			address = net.JoinHostPort(u.Hostname(), strconv.Itoa(ac.Port))
+		}
+	}
+	timeout := time.Duration(ac.Timeout)
+	if timeout == 0 {
+		timeout = time.Duration(c.HealthCheck.Timeout)
+	}
+	return health.AgentConfig{
+		Address: address,
+		Timeout: timeout,
+		Send:    []byte(ac.Send),
+	}, true
+}
+
+// healthConfig converts merged health check settings to a health.Config.
+// The checks of a composite inherit its settings.
+func (hc HealthCheckConfig) healthConfig() (health.Config, error) {
+	config := health.Config{
+		Type:         health.CheckType(hc.Type),
+		Endpoint:     hc.URL,
+		Interval:     time.Duration(hc.Interval),
+		Timeout:      time.Duration(hc.Timeout),
+		Method:       hc.Method,
+		Path:         hc.Path,
+		BodyContains: hc.BodyContains,
+		JSONPath:     hc.JSONPath,
+		JSONValue:    hc.JSONValue,
+		Port:         hc.Port,
+		Send:         []byte(hc.Send),
+		Expect:       []byte(hc.Expect),
+		Service:      hc.Service,
+		Command:      hc.Command,
+		Mode:         health.CompositeMode(hc.Mode),
+	}
+
+	base := hc
+	base.Mode = ""
+	base.Checks = nil
+	for _, check := range hc.Checks {
+		if check.Type == "" {
+			return health.Config{}, errors.New("composite health checks require a type for each check")
+		}
+		c, err := base.merge(check).healthConfig()
+		if err != nil {
+			return health.Config{}, err
+		}
+		config.Checks = append(config.Checks, c)
+	}
+
+	if len(hc.Headers) > 0 {
+		config.Headers = make(http.Header)
+		for name, value := range hc.Headers {
+			config.Headers.Set(name, value)
+		}
+	}
+
+	for _, code := range hc.StatusCodes {
+		r, err := health.ParseStatusRange(code)
+		if err != nil {
+			return health.Config{}, err
+		}
+		config.StatusRanges = append(config.StatusRanges, r)
+	}
+
+	if hc.BodyRegex != "" {
+		re, err := regexp.Compile(hc.BodyRegex)
+		if err != nil {
+			return health.Config{}, fmt.Errorf("invalid body regex: %w", err)
+		}
+		config.BodyRegex = re
+	}
+
+	if hc.TLSSkipVerify || hc.CAFile != "" {
+		config.TLS = &tls.Config{InsecureSkipVerify: hc.TLSSkipVerify}
+		if hc.CAFile != "" {
+			pem, err := os.ReadFile(hc.CAFile)
+			if err != nil {
+				return health.Config{}, fmt.Errorf("failed to read CA file: %w", err)
+			}
+			config.TLS.RootCAs = x509.NewCertPool()
+			if !config.TLS.RootCAs.AppendCertsFromPEM(pem) {
+				return health.Config{}, fmt.Errorf("no certificates found in CA file %s", hc.CAFile)
+			}
+		}
+	}
+
+	return config, nil
+}
+
+// merge returns the settings with the non-empty overrides applied
+func (hc HealthCheckConfig) merge(o HealthCheckConfig) HealthCheckConfig {
+	if o.Type != "" {
+		hc.Type = o.Type
+	}
+	if o.Timeout != 0 {
+		hc.Timeout = o.Timeout
+	}
+	if o.URL != "" {
+		hc.URL = o.URL
+	}
+	if o.Port != 0 {
+		hc.Port = o.Port
+	}
+	if o.Path != "" {
+		hc.Path = o.Path
+	}
+	if o.Method != "" {
+		hc.Method = o.Method
+	}
+	if o.Headers != nil {
+		hc.Headers = o.Headers
+	}
+	if o.StatusCodes != nil {
+		hc.StatusCodes = o.StatusCodes
+	}
+	if o.BodyContains != "" {
+		hc.BodyContains = o.BodyContains
+	}
+	if o.BodyRegex != "" {
+		hc.BodyRegex = o.BodyRegex
+	}
+	if o.JSONPath != "" {
+		hc.JSONPath = o.JSONPath
+		hc.JSONValue = o.JSONValue
+	}
+	if o.TLSSkipVerify {
+		hc.TLSSkipVerify = true
+	}
+	if o.CAFile != "" {
+		hc.CAFile = o.CAFile
+	}
+	if o.Send != "" {
+		hc.Send = o.Send
+	}
+	if o.Expect != "" {
+		hc.Expect = o.Expect
+	}
+	if o.Service != "" {
+		hc.Service = o.Service
+	}
+	if o.Command != nil {
+		hc.Command = o.Command
+	}
+	if o.Mode != "" {
+		hc.Mode = o.Mode
+	}
+	if o.Checks != nil {
+		hc.Checks = o.Checks
+	}
+	return hc
+}
+
+// GetCircuitBreakerConfig converts the circuit breaker configuration to a circuitbreaker.Config
+func (c *Config) GetCircuitBreakerConfig() (circuitbreaker.Config, error) {
+	cb := c.CircuitBreaker
+	mode := circuitbreaker.Mode(cb.Mode)
+	switch mode {
+	case "", circuitbreaker.ModeCount, circuitbreaker.ModeWindow:
+	default:
+		return circuitbreaker.Config{}, fmt.Errorf("unknown circuit breaker mode %q", cb.Mode)
+	}
+	return circuitbreaker.Config{
+		Mode:                  mode,
+		FailureThreshold:      cb.FailureThreshold,
+		ResetTimeout:          time.Duration(cb.ResetTimeout),
+		HalfOpenLimit:         cb.HalfOpenLimit,
+		Window:                time.Duration(cb.Window),
+		Buckets:               cb.Buckets,
+		MinimumRequests:       cb.MinimumRequests,
+		FailureRateThreshold:  cb.FailureRateThreshold,
+		SlowCallDuration:      time.Duration(cb.SlowCallDuration),
+		SlowCallRateThreshold: cb.SlowCallRateThreshold,
+	}, nil
+}
+
+// GetOutlierConfig converts the outlier detection configuration to an outlier.Config
+func (c *Config) GetOutlierConfig() outlier.Config {
+	od := c.OutlierDetection
+	return outlier.Config{
+		Interval:                 time.Duration(od.Interval),
+		Consecutive5xx:           od.Consecutive5xx,
+		ConsecutiveGatewayErrors: od.ConsecutiveGatewayErrors,
+		BaseEjectionTime:         time.Duration(od.BaseEjectionTime),
+		MaxEjectionTime:          time.Duration(od.MaxEjectionTime),
+		MaxEjectionPercent:       od.MaxEjectionPercent,
+		SuccessRateMinHosts:      od.SuccessRateMinHosts,
+		SuccessRateRequestVolume: od.SuccessRateRequestVolume,
+		SuccessRateStdevFactor:   od.SuccessRateStdevFactor,
+		LatencyFactor:            od.LatencyFactor,
+	}
+}
+
 // GetSessionConfig converts the sticky session configuration to a session.Config
 func (c *Config) GetSessionConfig() session.Config {
 	return session.Config{
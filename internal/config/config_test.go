@@ -1,7 +1,14 @@
 package config
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"load-balancer/internal/circuitbreaker"
+	"load-balancer/internal/health"
 )
 
 func TestLoad(t *testing.T) {
@@ -14,3 +21,187 @@ func TestLoad(t *testing.T) {
 		t.Errorf("Expected port 8080, got %d", cfg.Server.Port)
 	}
 }
+
+// loadJSON loads a configuration from JSON text
+func loadJSON(t *testing.T, text string) (*Config, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	return Load(path)
+}
+
+func TestLoadRejectsUnknownProtocol(t *testing.T) {
+	_, err := loadJSON(t, `{"backends": [{"id": "grpc1", "url": "http://localhost:50051", "protocol": "http2"}]}`)
+	if err == nil || !strings.Contains(err.Error(), "grpc1") {
+		t.Errorf("Expected error naming backend grpc1, got %v", err)
+	}
+
+	for _, protocol := range []string{"", "http1", "h2", "h2c"} {
+		if _, err := loadJSON(t, `{"backends": [{"id": "b1", "url": "http://localhost", "protocol": "`+protocol+`"}]}`); err != nil {
+			t.Errorf("Protocol %q: unexpected error %v", protocol, err)
+		}
+	}
+}
+
+func TestLoadRejectsAgentWithoutPort(t *testing.T) {
+	tests := []struct {
+		name  string
+		agent string
+		valid bool
+	}{
+		{"port", `{"port": 9999}`, true},
+		{"address", `{"address": "10.0.0.5:9999"}`, true},
+		{"no port", `{}`, false},
+		{"zero port", `{"port": 0, "send": "status\n"}`, false},
+		{"address without port", `{"address": "10.0.0.5"}`, false},
+		{"address with zero port", `{"address": "10.0.0.5:0"}`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadJSON(t, `{"backends": [{"id": "web1", "url": "http://10.0.0.5", "agent_check": `+tt.agent+`}]}`)
+			if tt.valid && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if !tt.valid && (err == nil || !strings.Contains(err.Error(), "web1")) {
+				t.Errorf("Expected error naming backend web1, got %v", err)
+			}
+		})
+	}
+}
+
+func TestGetHealthCheckConfig(t *testing.T) {
+	cfg := &Config{}
+	cfg.HealthCheck = HealthCheckConfig{
+		Type:     "http",
+		Interval: Duration(5 * time.Second),
+		Timeout:  Duration(2 * time.Second),
+		Path:     "/health",
+	}
+
+	// Backends without overrides use the global settings
+	hc, err := cfg.GetHealthCheckConfig(BackendConfig{URL: "http://localhost:8081"})
+	if err != nil {
+		t.Fatalf("Failed to get health check config: %v", err)
+	}
+	if hc.Type != health.HTTPCheck || hc.Path != "/health" || hc.Endpoint != "http://localhost:8081" {
+		t.Errorf("Unexpected health check config: %+v", hc)
+	}
+
+	// Backend settings override the global ones
+	hc, err = cfg.GetHealthCheckConfig(BackendConfig{
+		URL: "tcp://localhost:6379",
+		HealthCheck: &HealthCheckConfig{
+			Type:   "tcp",
+			Send:   "PING\r\n",
+			Expect: "+PONG",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to get health check config: %v", err)
+	}
+	if hc.Type != health.TCPCheck || string(hc.Send) != "PING\r\n" || string(hc.Expect) != "+PONG" {
+		t.Errorf("Unexpected health check config: %+v", hc)
+	}
+	if hc.Timeout != 2*time.Second {
+		t.Errorf("Timeout = %v, want %v", hc.Timeout, 2*time.Second)
+	}
+
+	hc, err = cfg.GetHealthCheckConfig(BackendConfig{
+		URL:         "http://localhost:9090",
+		HealthCheck: &HealthCheckConfig{Type: "grpc", Service: "orders"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to get health check config: %v", err)
+	}
+	if hc.Type != health.GRPCCheck || hc.Service != "orders" {
+		t.Errorf("Unexpected health check config: %+v", hc)
+	}
+
+	// Composite checks inherit the merged settings
+	hc, err = cfg.GetHealthCheckConfig(BackendConfig{
+		URL: "http://localhost:8081",
+		HealthCheck: &HealthCheckConfig{
+			Type: "composite",
+			Mode: "all",
+			Checks: []HealthCheckConfig{
+				{Type: "tcp"},
+				{Type: "http", Path: "/ready"},
+				{Type: "exec", Command: []string{"check-db"}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to get health check config: %v", err)
+	}
+	if hc.Type != health.CompositeCheck || hc.Mode != health.CompositeAll || len(hc.Checks) != 3 {
+		t.Fatalf("Unexpected health check config: %+v", hc)
+	}
+	tcpCheck, httpCheck, execCheck := hc.Checks[0], hc.Checks[1], hc.Checks[2]
+	if tcpCheck.Type != health.TCPCheck || tcpCheck.Endpoint != "http://localhost:8081" || tcpCheck.Timeout != 2*time.Second {
+		t.Errorf("Unexpected tcp check config: %+v", tcpCheck)
+	}
+	if httpCheck.Type != health.HTTPCheck || httpCheck.Path != "/ready" {
+		t.Errorf("Unexpected http check config: %+v", httpCheck)
+	}
+	if execCheck.Type != health.ExecCheck || len(execCheck.Command) != 1 || execCheck.Command[0] != "check-db" {
+		t.Errorf("Unexpected exec check config: %+v", execCheck)
+	}
+
+	_, err = cfg.GetHealthCheckConfig(BackendConfig{
+		HealthCheck: &HealthCheckConfig{Type: "composite", Checks: []HealthCheckConfig{{Path: "/ready"}}},
+	})
+	if err == nil {
+		t.Error("Expected error for composite check without a type")
+	}
+}
+
+func TestGetAgentConfig(t *testing.T) {
+	cfg := &Config{}
+	cfg.HealthCheck.Timeout = Duration(2 * time.Second)
+
+	if _, ok := cfg.GetAgentConfig(BackendConfig{URL: "http://localhost:8081"}); ok {
+		t.Error("Expected no agent check for backend without one")
+	}
+
+	// The agent defaults to the backend's host and the health check timeout
+	ac, ok := cfg.GetAgentConfig(BackendConfig{
+		URL:        "http://10.0.0.1:8081",
+		AgentCheck: &AgentCheckConfig{Port: 9999, Send: "status\n"},
+	})
+	if !ok || ac.Address != "10.0.0.1:9999" || ac.Timeout != 2*time.Second || string(ac.Send) != "status\n" {
+		t.Errorf("Unexpected agent config: %+v", ac)
+	}
+
+	ac, _ = cfg.GetAgentConfig(BackendConfig{
+		URL:        "http://10.0.0.1:8081",
+		AgentCheck: &AgentCheckConfig{Address: "10.0.0.2:9999", Timeout: Duration(time.Second)},
+	})
+	if ac.Address != "10.0.0.2:9999" || ac.Timeout != time.Second {
+		t.Errorf("Unexpected agent config: %+v", ac)
+	}
+}
+
+func TestGetCircuitBreakerConfig(t *testing.T) {
+	cfg := &Config{}
+	cfg.CircuitBreaker.Mode = "window"
+	cfg.CircuitBreaker.Window = Duration(30 * time.Second)
+	cfg.CircuitBreaker.FailureRateThreshold = 25
+	cfg.CircuitBreaker.SlowCallDuration = Duration(time.Second)
+
+	cb, err := cfg.GetCircuitBreakerConfig()
+	if err != nil {
+		t.Fatalf("Failed to get circuit breaker config: %v", err)
+	}
+	if cb.Mode != circuitbreaker.ModeWindow || cb.Window != 30*time.Second ||
+		cb.FailureRateThreshold != 25 || cb.SlowCallDuration != time.Second {
+		t.Errorf("Unexpected circuit breaker config: %+v", cb)
+	}
+
+	cfg.CircuitBreaker.Mode = "percentage"
+	if _, err := cfg.GetCircuitBreakerConfig(); err == nil {
+		t.Error("Expected error for unknown circuit breaker mode")
+	}
+}
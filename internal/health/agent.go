@@ -0,0 +1,160 @@
+package health
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"load-balancer/internal/backend"
+)
+
+// maxAgentResponse limits the length of an agent response line
+const maxAgentResponse = 512
+
+// AgentState is an administrative state reported by a backend agent
+type AgentState string
+
+const (
+	// AgentUp returns a draining or maintenance backend to service
+	AgentUp AgentState = "up"
+	// AgentDrain stops new clients but keeps existing sticky sessions
+	AgentDrain AgentState = "drain"
+	// AgentMaint takes the backend out of service
+	AgentMaint AgentState = "maint"
+)
+
+// AgentStatus is the parsed response of a backend agent
+type AgentStatus struct {
+	// Weight is the reported percentage of the backend's configured weight,
+	// or -1 if none was reported
+	Weight int
+	// State is the reported administrative state, or empty if none was
+	// reported
+	State AgentState
+}
+
+// String returns the status in agent response form
+func (s AgentStatus) String() string {
+	var words []string
+	if s.State != "" {
+		words = append(words, string(s.State))
+	}
+	if s.Weight >= 0 {
+		words = append(words, strconv.Itoa(s.Weight)+"%")
+	}
+	if len(words) == 0 {
+		return "no status"
+	}
+	return strings.Join(words, " ")
+}
+
+// AgentConfig holds the configuration for an agent check
+type AgentConfig struct {
+	// Address is the host:port of the agent
+	Address string
+	Timeout time.Duration
+	// Send is written after connecting, before reading the response
+	Send []byte
+}
+
+// Agent polls a backend's agent, which reports the load the backend wants
+// (HAProxy agent-check style). The agent replies with a single line of words
+// separated by spaces or commas: a weight percentage such as "75%", and
+// "up" or "ready", "drain", or "maint"; "down", "fail" and "stopped" are
+// treated as "maint". Other words are ignored.
+type Agent struct {
+	config AgentConfig
+}
+
+// NewAgent creates a new agent check
+func NewAgent(config AgentConfig) *Agent {
+	return &Agent{config: config}
+}
+
+// Poll connects to the agent and reads its status
+func (a *Agent) Poll(ctx context.Context) (AgentStatus, error) {
+	dialer := net.Dialer{Timeout: a.config.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", a.config.Address)
+	if err != nil {
+		return AgentStatus{}, err
+	}
+	defer conn.Close()
+
+	if a.config.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(a.config.Timeout))
+	}
+	if d, ok := ctx.Deadline(); ok {
+		if a.config.Timeout <= 0 || d.Before(time.Now().Add(a.config.Timeout)) {
+			conn.SetDeadline(d)
+		}
+	}
+
+	if len(a.config.Send) > 0 {
+		if _, err := conn.Write(a.config.Send); err != nil {
+			return AgentStatus{}, err
+		}
+	}
+
+	// The response ends at a newline or when the agent closes the connection
+	line, err := bufio.NewReaderSize(conn, maxAgentResponse).ReadSlice('\n')
+	if err != nil && len(line) == 0 {
+		return AgentStatus{}, fmt.Errorf("no agent response: %w", err)
+	}
+	return ParseAgentResponse(string(line))
+}
+
+// ParseAgentResponse parses an agent response line
+func ParseAgentResponse(response string) (AgentStatus, error) {
+	status := AgentStatus{Weight: -1}
+	words := strings.FieldsFunc(response, func(r rune) bool {
+		return r == ' ' || r == ',' || r == '\t' || r == '\r' || r == '\n'
+	})
+	for _, word := range words {
+		word = strings.ToLower(word)
+		switch word {
+		case "up", "ready":
+			status.State = AgentUp
+		case "drain":
+			status.State = AgentDrain
+		case "maint", "down", "fail", "stopped":
+			status.State = AgentMaint
+		default:
+			if percent, ok := strings.CutSuffix(word, "%"); ok {
+				weight, err := strconv.Atoi(percent)
+				if err != nil || weight < 0 {
+					return AgentStatus{}, fmt.Errorf("invalid agent weight %q", word)
+				}
+				status.Weight = weight
+			}
+		}
+	}
+	return status, nil
+}
+
+// Apply sets the backend's weight from the reported percentage of its
+// configured weight, and its draining and maintenance states. A non-zero
+// percentage never lowers the weight to zero.
+func (s AgentStatus) Apply(b *backend.Backend, configuredWeight int) {
+	if s.Weight >= 0 {
+		weight := configuredWeight * s.Weight / 100
+		if s.Weight > 0 && weight == 0 {
+			weight = 1
+		}
+		b.SetWeight(weight)
+	}
+
+	switch s.State {
+	case AgentUp:
+		b.SetDraining(false)
+		b.SetMaintenance(false)
+	case AgentDrain:
+		b.SetDraining(true)
+		b.SetMaintenance(false)
+	case AgentMaint:
+		b.SetMaintenance(true)
+	}
+}
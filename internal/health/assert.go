@@ -0,0 +1,123 @@
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxBodyRead limits how much of a response body is read for assertions
+const maxBodyRead = 1 << 20
+
+// StatusRange is an inclusive range of acceptable status codes
+type StatusRange struct {
+	Min int
+	Max int
+}
+
+// ParseStatusRange parses a status code ("204") or range ("200-299")
+func ParseStatusRange(s string) (StatusRange, error) {
+	first, last, isRange := strings.Cut(strings.TrimSpace(s), "-")
+	low, err := strconv.Atoi(strings.TrimSpace(first))
+	if err != nil {
+		return StatusRange{}, fmt.Errorf("invalid status range %q", s)
+	}
+	high := low
+	if isRange {
+		if high, err = strconv.Atoi(strings.TrimSpace(last)); err != nil || high < low {
+			return StatusRange{}, fmt.Errorf("invalid status range %q", s)
+		}
+	}
+	return StatusRange{Min: low, Max: high}, nil
+}
+
+// Contains reports whether a status code is in the range
+func (r StatusRange) Contains(code int) bool {
+	return code >= r.Min && code <= r.Max
+}
+
+// assert checks a response against the configured status and body assertions
+func (c *HTTPChecker) assert(resp *http.Response) error {
+	if !c.statusOK(resp.StatusCode) {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	if c.config.BodyContains == "" && c.config.BodyRegex == nil && c.config.JSONPath == "" {
+		return nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodyRead))
+	if err != nil {
+		return fmt.Errorf("failed to read body: %w", err)
+	}
+
+	if c.config.BodyContains != "" && !strings.Contains(string(body), c.config.BodyContains) {
+		return fmt.Errorf("body does not contain %q", c.config.BodyContains)
+	}
+	if c.config.BodyRegex != nil && !c.config.BodyRegex.Match(body) {
+		return fmt.Errorf("body does not match %q", c.config.BodyRegex)
+	}
+	if c.config.JSONPath != "" {
+		value, err := jsonPathValue(body, c.config.JSONPath)
+		if err != nil {
+			return err
+		}
+		if value != c.config.JSONValue {
+			return fmt.Errorf("%s is %q, want %q", c.config.JSONPath, value, c.config.JSONValue)
+		}
+	}
+	return nil
+}
+
+// statusOK reports whether a status code is acceptable
+func (c *HTTPChecker) statusOK(code int) bool {
+	if len(c.config.StatusRanges) == 0 {
+		return code == c.config.StatusCode
+	}
+	for _, r := range c.config.StatusRanges {
+		if r.Contains(code) {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonPathValue returns the value at a dot-separated path in a JSON document,
+// e.g. "checks.db.status" or "replicas.0.state". Objects and arrays are
+// returned as JSON, strings without quotes.
+func jsonPathValue(body []byte, path string) (string, error) {
+	var value any
+	if err := json.Unmarshal(body, &value); err != nil {
+		return "", fmt.Errorf("invalid JSON body: %w", err)
+	}
+
+	for _, key := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]any:
+			field, ok := v[key]
+			if !ok {
+				return "", fmt.Errorf("%s not found", path)
+			}
+			value = field
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return "", fmt.Errorf("%s not found", path)
+			}
+			value = v[i]
+		default:
+			return "", fmt.Errorf("%s not found", path)
+		}
+	}
+
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
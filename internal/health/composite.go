@@ -0,0 +1,88 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CompositeMode selects how a composite check combines its checks
+type CompositeMode string
+
+const (
+	// CompositeAll passes when every check passes
+	CompositeAll CompositeMode = "all"
+	// CompositeAny passes when at least one check passes
+	CompositeAny CompositeMode = "any"
+)
+
+// CompositeChecker implements the Checker interface by running several checks
+// concurrently and combining their results
+type CompositeChecker struct {
+	mode      CompositeMode
+	checkers  []Checker
+	BackendID string
+}
+
+// NewCompositeChecker creates a checker that combines checks with AND (all)
+// or OR (any)
+func NewCompositeChecker(mode CompositeMode, checkers ...Checker) Checker {
+	if mode == "" {
+		mode = CompositeAll
+	}
+	return &CompositeChecker{
+		mode:     mode,
+		checkers: checkers,
+	}
+}
+
+// Check runs all checks and combines their results. The error lists the
+// failed checks.
+func (c *CompositeChecker) Check(ctx context.Context) Result {
+	start := time.Now()
+
+	results := make([]Result, len(c.checkers))
+	var wg sync.WaitGroup
+	for i, checker := range c.checkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = checker.Check(ctx)
+		}()
+	}
+	wg.Wait()
+
+	passed := 0
+	var errs []error
+	for i, result := range results {
+		if result.Success {
+			passed++
+			continue
+		}
+		errs = append(errs, fmt.Errorf("%s check: %w", c.checkers[i].Type(), result.Error))
+	}
+
+	var err error
+	switch {
+	case len(c.checkers) == 0:
+		err = errors.New("no checks configured")
+	case c.mode == CompositeAny && passed == 0,
+		c.mode != CompositeAny && passed < len(c.checkers):
+		err = errors.Join(errs...)
+	}
+
+	return Result{
+		BackendID: c.BackendID,
+		Success:   err == nil,
+		Error:     err,
+		Timestamp: time.Now(),
+		Latency:   time.Since(start),
+	}
+}
+
+// Type returns the type of health check
+func (c *CompositeChecker) Type() CheckType {
+	return CompositeCheck
+}
@@ -0,0 +1,88 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// maxExecOutput limits how much command output is reported in a failure
+const maxExecOutput = 256
+
+// ExecChecker implements the Checker interface by running a local command. A
+// check passes when the command exits with status zero within the timeout.
+// The backend endpoint is passed in the HEALTH_CHECK_ENDPOINT environment
+// variable.
+type ExecChecker struct {
+	config    Config
+	BackendID string
+}
+
+// NewExecChecker creates a new exec health checker for a command and its
+// arguments
+func NewExecChecker(command []string, config Config) Checker {
+	return &ExecChecker{
+		config: Config{
+			Type:     ExecCheck,
+			Endpoint: config.Endpoint,
+			Interval: config.Interval,
+			Timeout:  config.Timeout,
+			Command:  command,
+		},
+	}
+}
+
+// Check runs the command
+func (c *ExecChecker) Check(ctx context.Context) Result {
+	start := time.Now()
+	err := c.run(ctx)
+	return Result{
+		BackendID: c.BackendID,
+		Success:   err == nil,
+		Error:     err,
+		Timestamp: time.Now(),
+		Latency:   time.Since(start),
+	}
+}
+
+// Type returns the type of health check
+func (c *ExecChecker) Type() CheckType {
+	return c.config.Type
+}
+
+// run runs the command and reports its exit status and output on failure
+func (c *ExecChecker) run(ctx context.Context) error {
+	if len(c.config.Command) == 0 {
+		return errors.New("no command configured")
+	}
+	if c.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.config.Timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, c.config.Command[0], c.config.Command[1:]...)
+	cmd.Env = append(os.Environ(), "HEALTH_CHECK_ENDPOINT="+c.config.Endpoint)
+	// Don't wait on children that inherited the output after the command is killed
+	cmd.WaitDelay = time.Second
+
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("command timed out: %w", ctx.Err())
+	}
+	output = bytes.TrimSpace(output)
+	if len(output) > maxExecOutput {
+		output = output[:maxExecOutput]
+	}
+	if len(output) > 0 {
+		return fmt.Errorf("%w: %s", err, output)
+	}
+	return err
+}
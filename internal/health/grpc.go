@@ -0,0 +1,238 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// grpcHealthPath is the method of the standard gRPC health checking protocol
+const grpcHealthPath = "/grpc.health.v1.Health/Check"
+
+// maxGRPCResponse limits the size of a health check response message
+const maxGRPCResponse = 4 * 1024
+
+// GRPCServingStatus is the status reported by a grpc.health.v1 server
+type GRPCServingStatus int
+
+// Serving statuses defined by grpc.health.v1.HealthCheckResponse
+const (
+	GRPCUnknown        GRPCServingStatus = 0
+	GRPCServing        GRPCServingStatus = 1
+	GRPCNotServing     GRPCServingStatus = 2
+	GRPCServiceUnknown GRPCServingStatus = 3
+)
+
+// String returns the protocol name of the status
+func (s GRPCServingStatus) String() string {
+	switch s {
+	case GRPCUnknown:
+		return "UNKNOWN"
+	case GRPCServing:
+		return "SERVING"
+	case GRPCNotServing:
+		return "NOT_SERVING"
+	case GRPCServiceUnknown:
+		return "SERVICE_UNKNOWN"
+	default:
+		return "status " + strconv.Itoa(int(s))
+	}
+}
+
+// GRPCChecker implements the Checker interface for the gRPC health checking
+// protocol (grpc.health.v1.Health/Check). https endpoints are checked over
+// HTTP/2 with TLS, others over plaintext HTTP/2 (h2c).
+type GRPCChecker struct {
+	config    Config
+	client    *http.Client
+	BackendID string
+}
+
+// NewGRPCChecker creates a new gRPC health checker. The check passes when the
+// server reports Service, or the server as a whole if Service is empty, as
+// SERVING. A non-zero Port replaces the endpoint's port.
+func NewGRPCChecker(endpoint string, config Config) Checker {
+	config.Type = GRPCCheck
+	config.Endpoint = strings.TrimSuffix(endpoint, "/")
+	u, err := url.Parse(endpoint)
+	if err == nil && u.Host != "" && config.Port != 0 {
+		u.Host = net.JoinHostPort(u.Hostname(), strconv.Itoa(config.Port))
+		config.Endpoint = strings.TrimSuffix(u.String(), "/")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Protocols = new(http.Protocols)
+	if err == nil && u.Scheme == "https" {
+		transport.TLSClientConfig = config.TLS
+		transport.Protocols.SetHTTP2(true)
+	} else {
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	}
+	return &GRPCChecker{
+		config: config,
+		client: &http.Client{
+			Timeout:   config.Timeout,
+			Transport: transport,
+		},
+	}
+}
+
+// Check performs a gRPC health check
+func (c *GRPCChecker) Check(ctx context.Context) Result {
+	start := time.Now()
+	err := c.check(ctx)
+	return Result{
+		BackendID: c.BackendID,
+		Success:   err == nil,
+		Error:     err,
+		Timestamp: time.Now(),
+		Latency:   time.Since(start),
+	}
+}
+
+// Type returns the type of health check
+func (c *GRPCChecker) Type() CheckType {
+	return c.config.Type
+}
+
+// check calls Health/Check and verifies the serving status
+func (c *GRPCChecker) check(ctx context.Context) error {
+	body := grpcFrame(encodeHealthCheckRequest(c.config.Service))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.config.Endpoint+grpcHealthPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	message, err := io.ReadAll(io.LimitReader(resp.Body, maxGRPCResponse))
+	if err != nil {
+		return err
+	}
+
+	// Trailers-only responses carry the status in the header
+	code := resp.Trailer.Get("Grpc-Status")
+	if code == "" {
+		code = resp.Header.Get("Grpc-Status")
+	}
+	if code != "0" {
+		msg := resp.Trailer.Get("Grpc-Message")
+		if msg == "" {
+			msg = resp.Header.Get("Grpc-Message")
+		}
+		if msg, err := url.PathUnescape(msg); err == nil && msg != "" {
+			return fmt.Errorf("grpc status %s: %s", code, msg)
+		}
+		return fmt.Errorf("grpc status %q", code)
+	}
+
+	payload, err := parseGRPCFrame(message)
+	if err != nil {
+		return err
+	}
+	status, err := decodeHealthCheckResponse(payload)
+	if err != nil {
+		return err
+	}
+	if status != GRPCServing {
+		return fmt.Errorf("service %q is %s", c.config.Service, status)
+	}
+	return nil
+}
+
+// grpcFrame prefixes an uncompressed message with its gRPC length header
+func grpcFrame(message []byte) []byte {
+	frame := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+	return append(frame, message...)
+}
+
+// parseGRPCFrame returns the message of a single uncompressed gRPC frame
+func parseGRPCFrame(frame []byte) ([]byte, error) {
+	if len(frame) < 5 {
+		return nil, errors.New("grpc response has no message")
+	}
+	if frame[0] != 0 {
+		return nil, errors.New("grpc response is compressed")
+	}
+	n := binary.BigEndian.Uint32(frame[1:5])
+	if uint64(n) > uint64(len(frame)-5) {
+		return nil, errors.New("grpc response message is truncated")
+	}
+	return frame[5 : 5+n], nil
+}
+
+// encodeHealthCheckRequest encodes a grpc.health.v1.HealthCheckRequest, whose
+// only field is the service name (field 1, string)
+func encodeHealthCheckRequest(service string) []byte {
+	if service == "" {
+		return nil
+	}
+	message := []byte{0x0a}
+	message = binary.AppendUvarint(message, uint64(len(service)))
+	return append(message, service...)
+}
+
+// decodeHealthCheckResponse decodes the status (field 1, enum) of a
+// grpc.health.v1.HealthCheckResponse, skipping unknown fields
+func decodeHealthCheckResponse(message []byte) (GRPCServingStatus, error) {
+	errMalformed := errors.New("malformed health check response")
+	status := GRPCUnknown
+	for len(message) > 0 {
+		key, n := binary.Uvarint(message)
+		if n <= 0 {
+			return 0, errMalformed
+		}
+		message = message[n:]
+
+		field, wireType := key>>3, key&7
+		switch wireType {
+		case 0: // varint
+			v, n := binary.Uvarint(message)
+			if n <= 0 {
+				return 0, errMalformed
+			}
+			message = message[n:]
+			if field == 1 {
+				status = GRPCServingStatus(v)
+			}
+		case 1: // 64-bit
+			if len(message) < 8 {
+				return 0, errMalformed
+			}
+			message = message[8:]
+		case 2: // length-delimited
+			l, n := binary.Uvarint(message)
+			if n <= 0 || l > uint64(len(message)-n) {
+				return 0, errMalformed
+			}
+			message = message[n+int(l):]
+		case 5: // 32-bit
+			if len(message) < 4 {
+				return 0, errMalformed
+			}
+			message = message[4:]
+		default:
+			return 0, errMalformed
+		}
+	}
+	return status, nil
+}
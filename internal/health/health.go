@@ -1,12 +1,17 @@
 package health
 
 import (
+	"bytes"
 	"context"
-	"log"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
 	"time"
-
-	"load-balancer/internal/backend"
 )
 
 // CheckType represents the type of health check to perform
@@ -17,6 +22,14 @@ const (
 	HTTPCheck CheckType = "http"
 	// TCPCheck performs TCP connection check
 	TCPCheck CheckType = "tcp"
+	// UDPCheck sends a datagram probe
+	UDPCheck CheckType = "udp"
+	// GRPCCheck calls the gRPC health checking protocol
+	GRPCCheck CheckType = "grpc"
+	// ExecCheck runs a local command
+	ExecCheck CheckType = "exec"
+	// CompositeCheck combines several checks
+	CompositeCheck CheckType = "composite"
 )
 
 // Config holds the configuration for a health check
@@ -29,8 +42,30 @@ type Config struct {
 	Method     string
 	Path       string
 	StatusCode int
-	// TCP specific
+	// Headers are sent with the request; a Host header sets the request host
+	Headers http.Header
+	// StatusRanges are the acceptable status codes, replacing StatusCode
+	StatusRanges []StatusRange
+	// BodyContains, BodyRegex and JSONPath/JSONValue assert on the response body
+	BodyContains string
+	BodyRegex    *regexp.Regexp
+	JSONPath     string
+	JSONValue    string
+	// TLS configures HTTPS checks
+	TLS *tls.Config
+	// HTTP, TCP and gRPC specific: replaces the endpoint's port
 	Port int
+	// TCP and UDP specific
+	Send   []byte
+	Expect []byte
+	// gRPC specific: the service to check; empty checks the whole server
+	Service string
+	// Exec specific: the command and its arguments
+	Command []string
+	// Composite specific: the checks to combine and whether all or any must
+	// pass. Checks without an endpoint use the composite's.
+	Mode   CompositeMode
+	Checks []Config
 }
 
 // Result represents the result of a health check
@@ -50,6 +85,60 @@ type Checker interface {
 	Type() CheckType
 }
 
+// ErrUnknownCheckType is returned when a health check type is not supported
+var ErrUnknownCheckType = errors.New("unknown health check type")
+
+// NewChecker creates a health checker of the configured type for a backend
+// URL. TCP and UDP checks probe the URL's host and port; composite checks
+// create their checks recursively.
+func NewChecker(config Config) (Checker, error) {
+	switch config.Type {
+	case HTTPCheck, "":
+		return NewHTTPChecker(config.Endpoint, config), nil
+	case TCPCheck:
+		return NewTCPChecker(hostPort(config.Endpoint), config), nil
+	case UDPCheck:
+		return NewUDPChecker(hostPort(config.Endpoint), config), nil
+	case GRPCCheck:
+		return NewGRPCChecker(config.Endpoint, config), nil
+	case ExecCheck:
+		if len(config.Command) == 0 {
+			return nil, errors.New("exec health check requires a command")
+		}
+		return NewExecChecker(config.Command, config), nil
+	case CompositeCheck:
+		if len(config.Checks) == 0 {
+			return nil, errors.New("composite health check requires checks")
+		}
+		if config.Mode != "" && config.Mode != CompositeAll && config.Mode != CompositeAny {
+			return nil, fmt.Errorf("unknown composite health check mode %q", config.Mode)
+		}
+		checkers := make([]Checker, len(config.Checks))
+		for i, c := range config.Checks {
+			if c.Endpoint == "" {
+				c.Endpoint = config.Endpoint
+			}
+			checker, err := NewChecker(c)
+			if err != nil {
+				return nil, err
+			}
+			checkers[i] = checker
+		}
+		return NewCompositeChecker(config.Mode, checkers...), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownCheckType, config.Type)
+	}
+}
+
+// hostPort returns the host and port of a URL, or the endpoint itself if it
+// is not a URL
+func hostPort(endpoint string) string {
+	if u, err := url.Parse(endpoint); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return endpoint
+}
+
 // HTTPChecker implements the Checker interface for HTTP health checks
 type HTTPChecker struct {
 	config    Config
@@ -57,20 +146,34 @@ type HTTPChecker struct {
 	BackendID string
 }
 
-// NewHTTPChecker creates a new HTTP health checker
+// NewHTTPChecker creates a new HTTP health checker. The check sends a GET
+// and expects a 200 unless configured otherwise; redirects are not followed.
 func NewHTTPChecker(endpoint string, config Config) Checker {
+	config.Type = HTTPCheck
+	config.Endpoint = endpoint
+	if config.Port != 0 {
+		if u, err := url.Parse(endpoint); err == nil && u.Host != "" {
+			u.Host = net.JoinHostPort(u.Hostname(), strconv.Itoa(config.Port))
+			config.Endpoint = u.String()
+		}
+	}
+	if config.Method == "" {
+		config.Method = http.MethodGet
+	}
+	if config.StatusCode == 0 {
+		config.StatusCode = http.StatusOK
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config.TLS
 	return &HTTPChecker{
-		config: Config{
-			Type:       HTTPCheck,
-			Endpoint:   endpoint,
-			Interval:   config.Interval,
-			Timeout:    config.Timeout,
-			Method:     "GET",
-			Path:       config.Path,
-			StatusCode: http.StatusOK,
-		},
+		config: config,
 		client: &http.Client{
-			Timeout: config.Timeout,
+			Timeout:   config.Timeout,
+			Transport: transport,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
 		},
 	}
 }
@@ -78,38 +181,35 @@ func NewHTTPChecker(endpoint string, config Config) Checker {
 // Check performs an HTTP health check
 func (c *HTTPChecker) Check(ctx context.Context) Result {
 	start := time.Now()
-
-	req, err := http.NewRequestWithContext(ctx, c.config.Method, c.config.Endpoint+c.config.Path, nil)
-	if err != nil {
+	result := func(err error) Result {
 		return Result{
 			BackendID: c.BackendID,
-			Success:   false,
+			Success:   err == nil,
 			Error:     err,
 			Timestamp: time.Now(),
 			Latency:   time.Since(start),
 		}
 	}
 
-	resp, err := c.client.Do(req)
+	req, err := http.NewRequestWithContext(ctx, c.config.Method, c.config.Endpoint+c.config.Path, nil)
 	if err != nil {
-		return Result{
-			BackendID: c.BackendID,
-			Success:   false,
-			Error:     err,
-			Timestamp: time.Now(),
-			Latency:   time.Since(start),
+		return result(err)
+	}
+	for name, values := range c.config.Headers {
+		if http.CanonicalHeaderKey(name) == "Host" {
+			req.Host = values[0]
+			continue
 		}
+		req.Header[name] = values
 	}
-	defer resp.Body.Close()
 
-	success := resp.StatusCode == c.config.StatusCode
-	return Result{
-		BackendID: c.BackendID,
-		Success:   success,
-		Error:     nil,
-		Timestamp: time.Now(),
-		Latency:   time.Since(start),
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return result(err)
 	}
+	defer resp.Body.Close()
+
+	return result(c.assert(resp))
 }
 
 // Type returns the type of health check
@@ -117,72 +217,162 @@ func (c *HTTPChecker) Type() CheckType {
 	return c.config.Type
 }
 
-// Scheduler manages health checks for multiple backends
-type Scheduler struct {
-	interval time.Duration
-	checkers map[string]Checker
-	results  chan Result
-	stop     chan struct{}
-	backends map[string]*backend.Backend
+// TCPChecker implements the Checker interface for TCP health checks. A check
+// connects within the timeout, then optionally writes Send and waits for a
+// reply containing Expect.
+type TCPChecker struct {
+	config    Config
+	BackendID string
 }
 
-// NewScheduler creates a new health check scheduler
-func NewScheduler(interval time.Duration) *Scheduler {
-	return &Scheduler{
-		interval: interval,
-		checkers: make(map[string]Checker),
-		results:  make(chan Result, 100),
-		stop:     make(chan struct{}),
-		backends: make(map[string]*backend.Backend),
+// maxExpectRead limits how much of a TCP reply is searched for Expect
+const maxExpectRead = 64 * 1024
+
+// NewTCPChecker creates a new TCP health checker for a host:port address. A
+// non-zero Port replaces the address's port.
+func NewTCPChecker(address string, config Config) Checker {
+	if config.Port != 0 {
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			address = net.JoinHostPort(host, strconv.Itoa(config.Port))
+		}
+	}
+	return &TCPChecker{
+		config: Config{
+			Type:     TCPCheck,
+			Endpoint: address,
+			Interval: config.Interval,
+			Timeout:  config.Timeout,
+			Port:     config.Port,
+			Send:     config.Send,
+			Expect:   config.Expect,
+		},
 	}
 }
 
-// AddBackend adds a backend to be monitored
-func (s *Scheduler) AddBackend(backendID string, b *backend.Backend, checker Checker) {
-	s.backends[backendID] = b
-	s.checkers[backendID] = checker
-}
+// Check performs a TCP health check
+func (c *TCPChecker) Check(ctx context.Context) Result {
+	start := time.Now()
+	result := func(err error) Result {
+		return Result{
+			BackendID: c.BackendID,
+			Success:   err == nil,
+			Error:     err,
+			Timestamp: time.Now(),
+			Latency:   time.Since(start),
+		}
+	}
 
-// RemoveBackend removes a backend from monitoring
-func (s *Scheduler) RemoveBackend(backendID string) {
-	delete(s.backends, backendID)
-	delete(s.checkers, backendID)
-}
+	dialer := net.Dialer{Timeout: c.config.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", c.config.Endpoint)
+	if err != nil {
+		return result(err)
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(c.config.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	if len(c.config.Send) > 0 {
+		if _, err := conn.Write(c.config.Send); err != nil {
+			return result(err)
+		}
+	}
+	if len(c.config.Expect) == 0 {
+		return result(nil)
+	}
 
-// Start begins the health check scheduling
-func (s *Scheduler) Start() {
-	for backendID, checker := range s.checkers {
-		go s.runChecker(backendID, checker)
+	// Read until the expected bytes arrive, the peer closes or time runs out
+	var reply []byte
+	buf := make([]byte, 4096)
+	for len(reply) < maxExpectRead {
+		n, err := conn.Read(buf)
+		reply = append(reply, buf[:n]...)
+		if bytes.Contains(reply, c.config.Expect) {
+			return result(nil)
+		}
+		if err != nil {
+			return result(fmt.Errorf("expected reply not received: %w", err))
+		}
 	}
+	return result(errors.New("expected reply not received"))
 }
 
-// Stop stops all health checks
-func (s *Scheduler) Stop() {
-	close(s.stop)
+// Type returns the type of health check
+func (c *TCPChecker) Type() CheckType {
+	return c.config.Type
 }
 
-// Results returns the channel for health check results
-func (s *Scheduler) Results() <-chan Result {
-	return s.results
+// UDPChecker implements the Checker interface for UDP datagram probes. Without
+// an expected reply, a probe passes unless the backend refuses it with an ICMP
+// port unreachable before the timeout.
+type UDPChecker struct {
+	config    Config
+	BackendID string
 }
 
-func (s *Scheduler) runChecker(backendID string, checker Checker) {
-	ticker := time.NewTicker(s.interval)
-	defer ticker.Stop()
+// NewUDPChecker creates a new UDP health checker for a host:port address
+func NewUDPChecker(address string, config Config) Checker {
+	return &UDPChecker{
+		config: Config{
+			Type:     UDPCheck,
+			Endpoint: address,
+			Interval: config.Interval,
+			Timeout:  config.Timeout,
+			Send:     config.Send,
+			Expect:   config.Expect,
+		},
+	}
+}
 
-	for {
-		select {
-		case <-ticker.C:
-			result := checker.Check(context.Background())
-			s.results <- result
+// Check performs a UDP health check by sending the probe datagram
+func (c *UDPChecker) Check(ctx context.Context) Result {
+	start := time.Now()
+	result := func(err error) Result {
+		return Result{
+			BackendID: c.BackendID,
+			Success:   err == nil,
+			Error:     err,
+			Timestamp: time.Now(),
+			Latency:   time.Since(start),
+		}
+	}
 
-			// Update backend health status
-			if b, exists := s.backends[backendID]; exists {
-				log.Printf("Backend %s health check result: %v", backendID, result)
-				b.SetHealth(result.Success)
-			}
-		case <-s.stop:
-			return
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", c.config.Endpoint)
+	if err != nil {
+		return result(err)
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(c.config.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	if _, err := conn.Write(c.config.Send); err != nil {
+		return result(err)
+	}
+
+	reply := make([]byte, 64*1024)
+	n, err := conn.Read(reply)
+	if err != nil {
+		var ne net.Error
+		if errors.As(err, &ne) && ne.Timeout() && len(c.config.Expect) == 0 {
+			return result(nil)
 		}
+		return result(err)
 	}
+	if !bytes.Contains(reply[:n], c.config.Expect) {
+		return result(errors.New("unexpected probe reply"))
+	}
+	return result(nil)
+}
+
+// Type returns the type of health check
+func (c *UDPChecker) Type() CheckType {
+	return c.config.Type
 }
@@ -2,13 +2,22 @@ package health
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"load-balancer/internal/backend"
+	"load-balancer/internal/metrics"
 )
 
 func TestHTTPChecker(t *testing.T) {
@@ -46,6 +55,526 @@ func TestHTTPChecker(t *testing.T) {
 	}
 }
 
+func TestHTTPCheckerAssertions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method != http.MethodGet:
+			w.WriteHeader(http.StatusNoContent)
+		case r.Host == "status.internal":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"status":"ok","checks":{"db":{"status":"degraded"}},"replicas":[{"lag":3}]}`))
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("maintenance"))
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		config  Config
+		success bool
+	}{
+		{"default status", Config{}, false},
+		{"status range", Config{StatusRanges: []StatusRange{{200, 299}, {503, 503}}}, true},
+		{"method", Config{Method: http.MethodHead, StatusCode: http.StatusNoContent}, true},
+		{"body contains", Config{StatusCode: 503, BodyContains: "maintenance"}, true},
+		{"body contains mismatch", Config{StatusCode: 503, BodyContains: "ready"}, false},
+		{"body regex", Config{StatusCode: 503, BodyRegex: regexp.MustCompile(`^main`)}, true},
+		{"host header", Config{Headers: http.Header{"Host": {"status.internal"}}}, true},
+		{"json path", Config{
+			Headers:   http.Header{"Host": {"status.internal"}},
+			JSONPath:  "status",
+			JSONValue: "ok",
+		}, true},
+		{"nested json path", Config{
+			Headers:   http.Header{"Host": {"status.internal"}},
+			JSONPath:  "checks.db.status",
+			JSONValue: "ok",
+		}, false},
+		{"json array path", Config{
+			Headers:   http.Header{"Host": {"status.internal"}},
+			JSONPath:  "replicas.0.lag",
+			JSONValue: "3",
+		}, true},
+		{"missing json path", Config{
+			Headers:   http.Header{"Host": {"status.internal"}},
+			JSONPath:  "replicas.1.lag",
+			JSONValue: "3",
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Timeout = 1 * time.Second
+			checker := NewHTTPChecker(server.URL, tt.config)
+
+			if result := checker.Check(context.Background()); result.Success != tt.success {
+				t.Errorf("Success = %v, want %v (error: %v)", result.Success, tt.success, result.Error)
+			}
+		})
+	}
+}
+
+func TestHTTPCheckerTLSAndPort(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	// The backend URL points elsewhere; the health check port is the server's
+	endpoint := "https://127.0.0.1:1"
+	config := Config{Timeout: 1 * time.Second}
+	config.Port, _ = strconv.Atoi(port)
+
+	// Self-signed certificates fail verification
+	if result := NewHTTPChecker(endpoint, config).Check(context.Background()); result.Success {
+		t.Error("Expected failed health check for unverified certificate")
+	}
+
+	// Skipping verification or trusting the CA passes
+	config.TLS = &tls.Config{InsecureSkipVerify: true}
+	if result := NewHTTPChecker(endpoint, config).Check(context.Background()); !result.Success {
+		t.Errorf("Expected successful health check, got failure: %v", result.Error)
+	}
+	config.TLS = &tls.Config{RootCAs: x509.NewCertPool()}
+	config.TLS.RootCAs.AddCert(server.Certificate())
+	config.TLS.ServerName = "example.com"
+	if result := NewHTTPChecker(endpoint, config).Check(context.Background()); !result.Success {
+		t.Errorf("Expected successful health check, got failure: %v", result.Error)
+	}
+}
+
+func TestParseStatusRange(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    StatusRange
+		wantErr bool
+	}{
+		{"200", StatusRange{200, 200}, false},
+		{"200-299", StatusRange{200, 299}, false},
+		{" 300 - 399 ", StatusRange{300, 399}, false},
+		{"299-200", StatusRange{}, true},
+		{"2xx", StatusRange{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseStatusRange(tt.input)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseStatusRange(%q) = %v, %v, want %v, error %v", tt.input, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestTCPChecker(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	addr := l.Addr().String()
+
+	checker := NewTCPChecker(addr, Config{Timeout: 1 * time.Second})
+
+	// A listening port is healthy
+	if result := checker.Check(context.Background()); !result.Success {
+		t.Errorf("Expected successful health check, got failure: %v", result.Error)
+	}
+
+	// A closed port is not
+	l.Close()
+	if result := checker.Check(context.Background()); result.Success {
+		t.Error("Expected failed health check for closed port")
+	}
+}
+
+func TestTCPCheckerSendExpect(t *testing.T) {
+	// A Redis-like server answering PING
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			buf := make([]byte, 64)
+			if n, _ := conn.Read(buf); string(buf[:n]) == "PING\r\n" {
+				conn.Write([]byte("+PONG\r\n"))
+			}
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	tests := []struct {
+		name    string
+		config  Config
+		success bool
+	}{
+		{"expected reply", Config{Send: []byte("PING\r\n"), Expect: []byte("+PONG")}, true},
+		{"unexpected reply", Config{Send: []byte("PING\r\n"), Expect: []byte("+OK")}, false},
+		{"no reply", Config{Send: []byte("QUIT\r\n"), Expect: []byte("+PONG")}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The configured port replaces the endpoint's port
+			tt.config.Timeout = 1 * time.Second
+			tt.config.Port, _ = strconv.Atoi(port)
+			checker := NewTCPChecker("127.0.0.1:1", tt.config)
+
+			if result := checker.Check(context.Background()); result.Success != tt.success {
+				t.Errorf("Success = %v, want %v (error: %v)", result.Success, tt.success, result.Error)
+			}
+		})
+	}
+}
+
+func TestNewChecker(t *testing.T) {
+	tests := []struct {
+		checkType CheckType
+		want      CheckType
+		wantErr   bool
+	}{
+		{"", HTTPCheck, false},
+		{HTTPCheck, HTTPCheck, false},
+		{TCPCheck, TCPCheck, false},
+		{UDPCheck, UDPCheck, false},
+		{"icmp", "", true},
+	}
+	for _, tt := range tests {
+		checker, err := NewChecker(Config{Type: tt.checkType, Endpoint: "tcp://127.0.0.1:5432"})
+		if tt.wantErr {
+			if !errors.Is(err, ErrUnknownCheckType) {
+				t.Errorf("NewChecker(%q) error = %v, want %v", tt.checkType, err, ErrUnknownCheckType)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("NewChecker(%q) error = %v", tt.checkType, err)
+		}
+		if checker.Type() != tt.want {
+			t.Errorf("NewChecker(%q).Type() = %q, want %q", tt.checkType, checker.Type(), tt.want)
+		}
+	}
+
+	// TCP checks probe the URL's host and port
+	checker, _ := NewChecker(Config{Type: TCPCheck, Endpoint: "tcp://127.0.0.1:5432"})
+	if got := checker.(*TCPChecker).config.Endpoint; got != "127.0.0.1:5432" {
+		t.Errorf("Endpoint = %q, want %q", got, "127.0.0.1:5432")
+	}
+}
+
+// newGRPCHealthServer starts a grpc.health.v1 server over h2c, or TLS if
+// secure is set, reporting the given service statuses
+func newGRPCHealthServer(t *testing.T, secure bool, statuses map[string]GRPCServingStatus) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != grpcHealthPath || r.ProtoMajor != 2 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var service string
+		if len(body) > 7 {
+			service = string(body[7:])
+		}
+
+		w.Header().Set("Content-Type", "application/grpc")
+		status, ok := statuses[service]
+		if !ok {
+			// Trailers-only response
+			w.Header().Set("Grpc-Status", "5")
+			w.Header().Set("Grpc-Message", "unknown service")
+			return
+		}
+		w.Write(grpcFrame([]byte{0x08, byte(status)}))
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+	}))
+	server.Config.Protocols = new(http.Protocols)
+	server.Config.Protocols.SetHTTP2(true)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	if secure {
+		server.EnableHTTP2 = true
+		server.StartTLS()
+	} else {
+		server.Start()
+	}
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGRPCChecker(t *testing.T) {
+	statuses := map[string]GRPCServingStatus{
+		"":        GRPCServing,
+		"orders":  GRPCServing,
+		"billing": GRPCNotServing,
+	}
+	tests := []struct {
+		service string
+		success bool
+	}{
+		{"", true},
+		{"orders", true},
+		{"billing", false},
+		{"missing", false},
+	}
+
+	for _, secure := range []bool{false, true} {
+		server := newGRPCHealthServer(t, secure, statuses)
+		config := Config{Type: GRPCCheck, Endpoint: server.URL, Timeout: time.Second}
+		if secure {
+			config.TLS = server.Client().Transport.(*http.Transport).TLSClientConfig
+		}
+
+		for _, tt := range tests {
+			config.Service = tt.service
+			checker, err := NewChecker(config)
+			if err != nil {
+				t.Fatalf("NewChecker failed: %v", err)
+			}
+			result := checker.Check(context.Background())
+			if result.Success != tt.success {
+				t.Errorf("TLS %v, service %q: success = %v (%v), want %v",
+					secure, tt.service, result.Success, result.Error, tt.success)
+			}
+		}
+	}
+}
+
+func TestDecodeHealthCheckResponse(t *testing.T) {
+	// Unknown fields of every wire type are skipped
+	message := []byte{
+		0x10, 0x96, 0x01, // field 2 varint
+		0x19, 1, 2, 3, 4, 5, 6, 7, 8, // field 3 fixed64
+		0x22, 0x02, 'h', 'i', // field 4 bytes
+		0x2d, 1, 2, 3, 4, // field 5 fixed32
+		0x08, 0x02, // status NOT_SERVING
+	}
+	status, err := decodeHealthCheckResponse(message)
+	if err != nil || status != GRPCNotServing {
+		t.Errorf("Status = %v, %v, want %v", status, err, GRPCNotServing)
+	}
+
+	if _, err := decodeHealthCheckResponse([]byte{0x22, 0x05, 'h'}); err == nil {
+		t.Error("Expected error for truncated message")
+	}
+}
+
+func TestUDPChecker(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(append([]byte("pong:"), buf[:n]...), addr)
+		}
+	}()
+
+	checker := NewUDPChecker(conn.LocalAddr().String(), Config{
+		Timeout: 1 * time.Second,
+		Send:    []byte("ping"),
+		Expect:  []byte("pong"),
+	})
+	if result := checker.Check(context.Background()); !result.Success {
+		t.Errorf("Expected successful health check, got failure: %v", result.Error)
+	}
+
+	checker = NewUDPChecker(conn.LocalAddr().String(), Config{
+		Timeout: 1 * time.Second,
+		Send:    []byte("ping"),
+		Expect:  []byte("ok"),
+	})
+	if result := checker.Check(context.Background()); result.Success {
+		t.Error("Expected failed health check for unexpected reply")
+	}
+}
+
+func TestExecChecker(t *testing.T) {
+	tests := []struct {
+		name    string
+		command []string
+		success bool
+	}{
+		{"exit zero", []string{"sh", "-c", "exit 0"}, true},
+		{"exit non-zero", []string{"sh", "-c", "echo not ready; exit 1"}, false},
+		{"endpoint in environment", []string{"sh", "-c", `test "$HEALTH_CHECK_ENDPOINT" = http://backend`}, true},
+		{"timeout", []string{"sleep", "5"}, false},
+		{"missing command", []string{"/nonexistent"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker, err := NewChecker(Config{
+				Type:     ExecCheck,
+				Endpoint: "http://backend",
+				Timeout:  100 * time.Millisecond,
+				Command:  tt.command,
+			})
+			if err != nil {
+				t.Fatalf("NewChecker failed: %v", err)
+			}
+			result := checker.Check(context.Background())
+			if result.Success != tt.success {
+				t.Errorf("Success = %v (%v), want %v", result.Success, result.Error, tt.success)
+			}
+		})
+	}
+
+	checker, _ := NewChecker(Config{Type: ExecCheck, Command: []string{"sh", "-c", "echo not ready; exit 1"}})
+	if result := checker.Check(context.Background()); !strings.Contains(fmt.Sprint(result.Error), "not ready") {
+		t.Errorf("Error = %v, want command output", result.Error)
+	}
+}
+
+func TestCompositeChecker(t *testing.T) {
+	pass := checkerFunc(func(ctx context.Context) Result { return Result{Success: true} })
+	fail := checkerFunc(func(ctx context.Context) Result { return Result{Error: errors.New("down")} })
+
+	tests := []struct {
+		mode     CompositeMode
+		checkers []Checker
+		success  bool
+	}{
+		{CompositeAll, []Checker{pass, pass}, true},
+		{CompositeAll, []Checker{pass, fail}, false},
+		{CompositeAny, []Checker{fail, pass}, true},
+		{CompositeAny, []Checker{fail, fail}, false},
+		{CompositeAll, nil, false},
+	}
+	for _, tt := range tests {
+		result := NewCompositeChecker(tt.mode, tt.checkers...).Check(context.Background())
+		if result.Success != tt.success {
+			t.Errorf("%s of %d checks: success = %v (%v), want %v",
+				tt.mode, len(tt.checkers), result.Success, result.Error, tt.success)
+		}
+	}
+
+	// A backend can require both a TCP connect and an HTTP /ready check
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ready" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	for path, success := range map[string]bool{"/ready": true, "/health": false} {
+		checker, err := NewChecker(Config{
+			Type:     CompositeCheck,
+			Endpoint: server.URL,
+			Checks: []Config{
+				{Type: TCPCheck, Timeout: time.Second},
+				{Type: HTTPCheck, Timeout: time.Second, Path: path},
+			},
+		})
+		if err != nil {
+			t.Fatalf("NewChecker failed: %v", err)
+		}
+		if result := checker.Check(context.Background()); result.Success != success {
+			t.Errorf("Path %s: success = %v (%v), want %v", path, result.Success, result.Error, success)
+		}
+	}
+
+	if _, err := NewChecker(Config{Type: CompositeCheck, Mode: "most", Checks: []Config{{}}}); err == nil {
+		t.Error("Expected error for unknown composite mode")
+	}
+}
+
+func TestParseAgentResponse(t *testing.T) {
+	tests := []struct {
+		response string
+		want     AgentStatus
+		wantErr  bool
+	}{
+		{"75%\n", AgentStatus{Weight: 75}, false},
+		{"drain", AgentStatus{Weight: -1, State: AgentDrain}, false},
+		{"up 50%", AgentStatus{Weight: 50, State: AgentUp}, false},
+		{"READY,maxconn:30,0%", AgentStatus{Weight: 0, State: AgentUp}, false},
+		{"maint\r\n", AgentStatus{Weight: -1, State: AgentMaint}, false},
+		{"stopped", AgentStatus{Weight: -1, State: AgentMaint}, false},
+		{"", AgentStatus{Weight: -1}, false},
+		{"half%", AgentStatus{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseAgentResponse(tt.response)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseAgentResponse(%q) error = %v, wantErr %v", tt.response, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("ParseAgentResponse(%q) = %+v, want %+v", tt.response, got, tt.want)
+		}
+	}
+}
+
+func TestSchedulerAgent(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer l.Close()
+
+	// The agent replies with whatever status is queued for it
+	responses := make(chan string, 1)
+	go func() {
+		response := "up"
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			select {
+			case response = <-responses:
+			default:
+			}
+			conn.Write([]byte(response + "\n"))
+			conn.Close()
+		}
+	}()
+
+	scheduler := NewScheduler(10 * time.Millisecond)
+	b := backend.New("test-backend", "http://localhost", 4)
+	scheduler.AddBackend(b.ID(), b, checkerFunc(func(ctx context.Context) Result {
+		return Result{Success: true}
+	}))
+	scheduler.SetAgent(b.ID(), NewAgent(AgentConfig{Address: l.Addr().String(), Timeout: time.Second}))
+	scheduler.Start()
+	defer scheduler.Stop()
+
+	waitFor := func(desc string, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("Timeout waiting for %s", desc)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	// Percentages apply to the configured weight
+	responses <- "50%"
+	waitFor("weight 2", func() bool { return b.Weight() == 2 })
+	responses <- "drain 100%"
+	waitFor("drain", func() bool { return b.IsDraining() && b.Weight() == 4 })
+	if b.IsAvailable() || !b.CanServeSession() {
+		t.Error("Expected draining backend to only serve existing sessions")
+	}
+	responses <- "maint"
+	waitFor("maintenance", func() bool { return b.InMaintenance() })
+	if b.CanServeSession() {
+		t.Error("Expected backend in maintenance to serve no sessions")
+	}
+	responses <- "ready"
+	waitFor("up", func() bool { return !b.InMaintenance() && !b.IsDraining() && b.IsAvailable() })
+}
+
 func TestScheduler(t *testing.T) {
 	// Create a test server
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -121,6 +650,55 @@ done:
 	}
 }
 
+func TestSchedulerThresholds(t *testing.T) {
+	m := metrics.New()
+	scheduler := NewScheduler(time.Hour)
+	scheduler.SetThresholds(2, 3)
+	scheduler.SetInitialState(false)
+	scheduler.SetMetrics(m)
+
+	b := backend.New("test-backend", "http://localhost", 1)
+	scheduler.AddBackend(b.ID(), b, NewHTTPChecker("http://localhost", Config{}))
+	if b.IsHealthy {
+		t.Fatal("Expected backend to start unhealthy")
+	}
+
+	steps := []struct {
+		success bool
+		healthy bool
+	}{
+		{true, false}, // one success is not enough to rise
+		{false, false},
+		{true, false},
+		{true, true}, // second consecutive success
+		{false, true},
+		{false, true},
+		{true, true}, // a success resets the failure count
+		{false, true},
+		{false, true},
+		{false, false}, // third consecutive failure
+	}
+	for i, step := range steps {
+		scheduler.record(b.ID(), b, Result{Success: step.success, Timestamp: time.Now()})
+		if b.IsHealthy != step.healthy {
+			t.Fatalf("Step %d: healthy = %v, want %v", i, b.IsHealthy, step.healthy)
+		}
+	}
+
+	history := scheduler.History(b.ID())
+	if len(history) != 2 || !history[0].Healthy || history[1].Healthy {
+		t.Errorf("History = %+v, want up then down", history)
+	}
+
+	stats := m.GetStats()
+	if got := stats["health_check_failures"].(map[string]int64)[b.ID()]; got != 6 {
+		t.Errorf("Health check failures = %d, want 6", got)
+	}
+	if got := stats["health_transitions"].(map[string]int64)[b.ID()]; got != 2 {
+		t.Errorf("Health transitions = %d, want 2", got)
+	}
+}
+
 func TestSchedulerStop(t *testing.T) {
 	scheduler := NewScheduler(5 * time.Second)
 
@@ -155,3 +733,100 @@ func TestSchedulerStop(t *testing.T) {
 		// Expected timeout
 	}
 }
+
+// checkerFunc adapts a function to the Checker interface
+type checkerFunc func(ctx context.Context) Result
+
+func (f checkerFunc) Check(ctx context.Context) Result {
+	return f(ctx)
+}
+
+func (f checkerFunc) Type() CheckType {
+	return HTTPCheck
+}
+
+func TestSchedulerDynamicBackends(t *testing.T) {
+	scheduler := NewScheduler(10 * time.Millisecond)
+	scheduler.Start()
+	defer scheduler.Stop()
+	results := scheduler.Subscribe(100)
+
+	// A backend added while running is checked
+	b := backend.New("added", "http://localhost", 1)
+	scheduler.AddBackend(b.ID(), b, checkerFunc(func(ctx context.Context) Result {
+		return Result{Success: true, Timestamp: time.Now()}
+	}))
+	select {
+	case result := <-results:
+		if result.BackendID != "added" {
+			t.Errorf("BackendID = %q, want %q", result.BackendID, "added")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for result from added backend")
+	}
+
+	// Removing a backend cancels its check in progress
+	started := make(chan struct{}, 1)
+	cancelled := make(chan struct{})
+	slow := backend.New("slow", "http://localhost", 1)
+	scheduler.AddBackend(slow.ID(), slow, checkerFunc(func(ctx context.Context) Result {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-ctx.Done()
+		close(cancelled)
+		return Result{Error: ctx.Err()}
+	}))
+	select {
+	case <-started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for slow check to start")
+	}
+	scheduler.RemoveBackend(slow.ID())
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("Check was not cancelled when its backend was removed")
+	}
+	if !slow.IsAvailable() {
+		t.Error("Cancelled check changed the health of a removed backend")
+	}
+
+	// No results arrive once the backend is removed
+	scheduler.RemoveBackend(b.ID())
+	time.Sleep(50 * time.Millisecond)
+	for len(results) > 0 {
+		<-results
+	}
+	select {
+	case result := <-results:
+		t.Errorf("Received result for %q after removal", result.BackendID)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSchedulerResultsDoNotBlock(t *testing.T) {
+	scheduler := NewScheduler(time.Millisecond)
+	scheduler.Subscribe(1)
+
+	// Checks keep running with no one reading results
+	checks := make(chan struct{}, 1)
+	count := 0
+	b := backend.New("test-backend", "http://localhost", 1)
+	scheduler.AddBackend(b.ID(), b, checkerFunc(func(ctx context.Context) Result {
+		count++
+		if count == 2*DefaultResultsBuffer {
+			close(checks)
+		}
+		return Result{Success: true, Timestamp: time.Now()}
+	}))
+	scheduler.Start()
+	defer scheduler.Stop()
+
+	select {
+	case <-checks:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Checks blocked on unread results")
+	}
+}
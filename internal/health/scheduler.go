@@ -0,0 +1,279 @@
+package health
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"load-balancer/internal/backend"
+	"load-balancer/internal/metrics"
+)
+
+// DefaultResultsBuffer is the size of the Results channel
+const DefaultResultsBuffer = 100
+
+// monitor is a backend watched by the scheduler
+type monitor struct {
+	backend *backend.Backend
+	checker Checker
+	state   state
+	// cancel stops the monitor's checks; nil until it is started
+	cancel context.CancelFunc
+
+	// agent, if set, is polled after each check to adjust the backend's
+	// configured weight and state
+	agent       *Agent
+	weight      int
+	agentStatus AgentStatus
+}
+
+// Scheduler manages health checks for multiple backends. A backend changes
+// health only after Rise consecutive successful or Fall consecutive failed
+// checks. Backends can be added and removed while the scheduler runs.
+type Scheduler struct {
+	interval       time.Duration
+	metrics        *metrics.Metrics
+	rise           int
+	fall           int
+	initialHealthy bool
+
+	mu          sync.Mutex
+	monitors    map[string]*monitor
+	results     chan Result
+	subscribers []chan Result
+	ctx         context.Context
+	cancel      context.CancelFunc
+	stopped     bool
+	wg          sync.WaitGroup
+}
+
+// NewScheduler creates a new health check scheduler
+func NewScheduler(interval time.Duration) *Scheduler {
+	results := make(chan Result, DefaultResultsBuffer)
+	return &Scheduler{
+		interval:       interval,
+		rise:           1,
+		fall:           1,
+		initialHealthy: true,
+		monitors:       make(map[string]*monitor),
+		results:        results,
+		subscribers:    []chan Result{results},
+	}
+}
+
+// SetThresholds sets the consecutive successful checks needed to mark a
+// backend healthy (rise) and failed checks needed to mark it unhealthy (fall)
+func (s *Scheduler) SetThresholds(rise, fall int) {
+	s.rise = max(rise, 1)
+	s.fall = max(fall, 1)
+}
+
+// SetInitialState sets the health of backends when they are added
+func (s *Scheduler) SetInitialState(healthy bool) {
+	s.initialHealthy = healthy
+}
+
+// SetMetrics sets the metrics that record check failures and health changes
+func (s *Scheduler) SetMetrics(m *metrics.Metrics) {
+	s.metrics = m
+}
+
+// AddBackend adds a backend to be monitored, setting it to the initial state.
+// If the scheduler is running, the backend's checks start right away; a
+// backend already monitored under the same ID is replaced.
+func (s *Scheduler) AddBackend(backendID string, b *backend.Backend, checker Checker) {
+	m := &monitor{
+		backend: b,
+		checker: checker,
+		state:   state{healthy: s.initialHealthy},
+	}
+
+	s.mu.Lock()
+	if old, ok := s.monitors[backendID]; ok && old.cancel != nil {
+		old.cancel()
+	}
+	s.monitors[backendID] = m
+	if s.ctx != nil && !s.stopped {
+		s.startLocked(backendID, m)
+	}
+	s.mu.Unlock()
+
+	b.SetHealth(s.initialHealthy)
+	if s.metrics != nil {
+		s.metrics.SetBackendHealth(backendID, s.initialHealthy)
+	}
+}
+
+// SetAgent sets an agent check for a monitored backend. The agent's weight
+// percentages apply to the backend's weight at the time SetAgent is called.
+func (s *Scheduler) SetAgent(backendID string, agent *Agent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m, ok := s.monitors[backendID]; ok {
+		m.agent = agent
+		m.weight = m.backend.Weight()
+		m.agentStatus = AgentStatus{Weight: -1}
+	}
+}
+
+// RemoveBackend removes a backend from monitoring, cancelling any check in
+// progress
+func (s *Scheduler) RemoveBackend(backendID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m, ok := s.monitors[backendID]; ok {
+		if m.cancel != nil {
+			m.cancel()
+		}
+		delete(s.monitors, backendID)
+	}
+}
+
+// Start begins the health check scheduling
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx != nil || s.stopped {
+		return
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	for backendID, m := range s.monitors {
+		s.startLocked(backendID, m)
+	}
+}
+
+// Stop stops all health checks and waits for checks in progress to return
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return
+	}
+	s.stopped = true
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+// Results returns the channel for health check results. Results are dropped
+// when the channel is full rather than delaying checks.
+func (s *Scheduler) Results() <-chan Result {
+	return s.results
+}
+
+// Subscribe returns a new channel that receives every health check result.
+// Like Results, results are dropped when the channel is full.
+func (s *Scheduler) Subscribe(size int) <-chan Result {
+	ch := make(chan Result, size)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscribers = append(s.subscribers, ch)
+	return ch
+}
+
+// startLocked starts a monitor's checks. The caller must hold s.mu.
+func (s *Scheduler) startLocked(backendID string, m *monitor) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	m.cancel = cancel
+	s.wg.Add(1)
+	go s.run(ctx, backendID, m)
+}
+
+// run checks a backend every interval until its context is cancelled. The
+// first check is delayed by a random fraction of the interval so backends
+// added together are not probed in lockstep.
+func (s *Scheduler) run(ctx context.Context, backendID string, m *monitor) {
+	defer s.wg.Done()
+
+	timer := time.NewTimer(jitter(s.interval))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			s.check(ctx, backendID, m)
+			timer.Reset(s.interval)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// check runs a single check bounded by the interval and publishes its result
+func (s *Scheduler) check(ctx context.Context, backendID string, m *monitor) {
+	checkCtx, cancel := context.WithTimeout(ctx, s.interval)
+	defer cancel()
+
+	result := m.checker.Check(checkCtx)
+	if ctx.Err() != nil {
+		// The backend was removed or the scheduler stopped during the check
+		return
+	}
+	if result.BackendID == "" {
+		result.BackendID = backendID
+	}
+
+	// Record before publishing so subscribers see the updated backend health
+	s.record(backendID, m.backend, result)
+	s.publish(result)
+
+	s.pollAgent(ctx, backendID, m)
+}
+
+// pollAgent applies the status reported by the backend's agent, if any. A
+// failed poll leaves the backend's weight and state unchanged.
+func (s *Scheduler) pollAgent(ctx context.Context, backendID string, m *monitor) {
+	s.mu.Lock()
+	agent, weight := m.agent, m.weight
+	s.mu.Unlock()
+	if agent == nil {
+		return
+	}
+
+	pollCtx, cancel := context.WithTimeout(ctx, s.interval)
+	defer cancel()
+
+	status, err := agent.Poll(pollCtx)
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		log.Printf("Agent check for backend %s failed: %v", backendID, err)
+		return
+	}
+
+	s.mu.Lock()
+	changed := status != m.agentStatus
+	m.agentStatus = status
+	s.mu.Unlock()
+
+	if changed {
+		log.Printf("Agent for backend %s reported %s", backendID, status)
+	}
+	status.Apply(m.backend, weight)
+}
+
+// publish sends a result to every subscriber without blocking
+func (s *Scheduler) publish(result Result) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ch := range s.subscribers {
+		select {
+		case ch <- result:
+		default:
+		}
+	}
+}
+
+// jitter returns a random duration in [0, interval)
+func jitter(interval time.Duration) time.Duration {
+	if interval <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(interval)))
+}
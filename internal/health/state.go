@@ -0,0 +1,96 @@
+package health
+
+import (
+	"log"
+	"time"
+
+	"load-balancer/internal/backend"
+)
+
+// maxHistory is the number of health transitions kept per backend
+const maxHistory = 10
+
+// Transition records a change in a backend's health
+type Transition struct {
+	Healthy   bool
+	Timestamp time.Time
+	// Error is the failure that marked the backend unhealthy
+	Error error
+}
+
+// state tracks the consecutive check results of a backend
+type state struct {
+	healthy   bool
+	successes int
+	failures  int
+	history   []Transition
+}
+
+// record applies a check result to the backend's state, changing its health
+// once the rise or fall threshold is reached
+func (s *Scheduler) record(backendID string, b *backend.Backend, result Result) {
+	if !result.Success && s.metrics != nil {
+		s.metrics.IncrementHealthCheckFailures(backendID)
+	}
+
+	s.mu.Lock()
+	m, ok := s.monitors[backendID]
+	if !ok || m.backend != b {
+		s.mu.Unlock()
+		return
+	}
+	st := &m.state
+	if result.Success {
+		st.successes++
+		st.failures = 0
+	} else {
+		st.failures++
+		st.successes = 0
+	}
+
+	changed := false
+	if !st.healthy && st.successes >= s.rise {
+		st.healthy, changed = true, true
+	} else if st.healthy && st.failures >= s.fall {
+		st.healthy, changed = false, true
+	}
+	if changed {
+		st.history = append(st.history, Transition{
+			Healthy:   st.healthy,
+			Timestamp: result.Timestamp,
+			Error:     result.Error,
+		})
+		if len(st.history) > maxHistory {
+			st.history = st.history[len(st.history)-maxHistory:]
+		}
+	}
+	healthy := st.healthy
+	s.mu.Unlock()
+
+	if changed {
+		log.Printf("Backend %s is now %s: %v", backendID, healthState(healthy), result)
+	}
+	b.SetHealth(healthy)
+	if s.metrics != nil {
+		s.metrics.SetBackendHealth(backendID, healthy)
+	}
+}
+
+// History returns the most recent health transitions of a backend, oldest first
+func (s *Scheduler) History(backendID string) []Transition {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.monitors[backendID]
+	if !ok {
+		return nil
+	}
+	return append([]Transition(nil), m.state.history...)
+}
+
+// healthState describes a health status for logging
+func healthState(healthy bool) string {
+	if healthy {
+		return "healthy"
+	}
+	return "unhealthy"
+}
@@ -14,13 +14,34 @@ type Metrics struct {
 	// Prometheus metrics
 	totalRequests       atomic.Int64
 	failedRequests      atomic.Int64
+	retriesDenied       atomic.Int64
 	activeConnections   map[string]int64
 	backendRequests     map[string]int64
 	backendFailures     map[string]int64
 	backendLatencies    map[string]int64
 	healthCheckFailures map[string]int64
+
+	// Backend health state and its history
+	backendHealthy    map[string]int64
+	healthTransitions map[string]int64
+	healthLastChange  map[string]int64
+
+	// Outlier detection ejections per backend
+	outlierEjections map[string]int64
+
+	// Circuit breaker state and its changes per backend
+	circuitState       map[string]int64
+	circuitTransitions map[string]int64
+
+	// Histogram of attempts per proxied request
+	requestAttempts      []int64
+	requestAttemptsSum   int64
+	requestAttemptsCount int64
 }
 
+// attemptBuckets are the upper bounds of the request attempts histogram
+var attemptBuckets = []int{1, 2, 3, 4, 5}
+
 // New creates a new Metrics instance
 func New() *Metrics {
 	return &Metrics{
@@ -29,6 +50,13 @@ func New() *Metrics {
 		backendFailures:     make(map[string]int64),
 		backendLatencies:    make(map[string]int64),
 		healthCheckFailures: make(map[string]int64),
+		backendHealthy:      make(map[string]int64),
+		healthTransitions:   make(map[string]int64),
+		healthLastChange:    make(map[string]int64),
+		outlierEjections:    make(map[string]int64),
+		circuitState:        make(map[string]int64),
+		circuitTransitions:  make(map[string]int64),
+		requestAttempts:     make([]int64, len(attemptBuckets)),
 	}
 }
 
@@ -42,6 +70,11 @@ func (m *Metrics) IncrementFailedRequests() {
 	m.failedRequests.Add(1)
 }
 
+// IncrementRetriesDenied increments the counter of retries denied by the retry budget
+func (m *Metrics) IncrementRetriesDenied() {
+	m.retriesDenied.Add(1)
+}
+
 // IncrementActiveConnections increments the active connections for a backend
 func (m *Metrics) IncrementActiveConnections(backendID string) {
 	m.mu.Lock()
@@ -86,6 +119,63 @@ func (m *Metrics) IncrementHealthCheckFailures(backendID string) {
 	m.healthCheckFailures[backendID]++
 }
 
+// SetBackendHealth records the health state of a backend, counting changes
+// and the time of the last change
+func (m *Metrics) SetBackendHealth(backendID string, healthy bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var value int64
+	if healthy {
+		value = 1
+	}
+	previous, known := m.backendHealthy[backendID]
+	m.backendHealthy[backendID] = value
+	if !known || previous != value {
+		if known {
+			m.healthTransitions[backendID]++
+		}
+		m.healthLastChange[backendID] = time.Now().Unix()
+	}
+}
+
+// IncrementOutlierEjections increments the outlier ejection counter for a backend
+func (m *Metrics) IncrementOutlierEjections(backendID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.outlierEjections[backendID]++
+}
+
+// SetCircuitBreakerState records the circuit breaker state of a backend (0
+// closed, 1 open, 2 half-open), counting changes
+func (m *Metrics) SetCircuitBreakerState(backendID string, state int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	previous, known := m.circuitState[backendID]
+	m.circuitState[backendID] = int64(state)
+	if known && previous != int64(state) {
+		m.circuitTransitions[backendID]++
+	}
+}
+
+// RecordRequestAttempts records the number of backend attempts made for a request
+func (m *Metrics) RecordRequestAttempts(attempts int) {
+	if attempts <= 0 {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, bound := range attemptBuckets {
+		if attempts <= bound {
+			m.requestAttempts[i]++
+		}
+	}
+	m.requestAttemptsSum += int64(attempts)
+	m.requestAttemptsCount++
+}
+
 // GetStats returns the current metrics
 func (m *Metrics) GetStats() map[string]interface{} {
 	m.mu.RLock()
@@ -94,11 +184,19 @@ func (m *Metrics) GetStats() map[string]interface{} {
 	return map[string]interface{}{
 		"total_requests":        m.totalRequests.Load(),
 		"failed_requests":       m.failedRequests.Load(),
+		"retries_denied":        m.retriesDenied.Load(),
 		"active_connections":    m.activeConnections,
 		"backend_requests":      m.backendRequests,
 		"backend_failures":      m.backendFailures,
 		"backend_latencies":     m.backendLatencies,
 		"health_check_failures": m.healthCheckFailures,
+		"backend_healthy":       m.backendHealthy,
+		"health_transitions":    m.healthTransitions,
+		"outlier_ejections":     m.outlierEjections,
+		"circuit_state":         m.circuitState,
+		"circuit_transitions":   m.circuitTransitions,
+		"request_attempts_sum":  m.requestAttemptsSum,
+		"request_attempts":      m.requestAttemptsCount,
 	}
 }
 
@@ -119,6 +217,11 @@ func (m *Metrics) GetPrometheusMetrics() string {
 	metrics += "# TYPE load_balancer_failed_requests counter\n"
 	metrics += "load_balancer_failed_requests " + strconv.FormatInt(m.failedRequests.Load(), 10) + "\n"
 
+	// Retries denied
+	metrics += "# HELP load_balancer_retries_denied Total number of retries denied by the retry budget\n"
+	metrics += "# TYPE load_balancer_retries_denied counter\n"
+	metrics += "load_balancer_retries_denied " + strconv.FormatInt(m.retriesDenied.Load(), 10) + "\n"
+
 	// Active connections
 	metrics += "# HELP load_balancer_active_connections Number of active connections per backend\n"
 	metrics += "# TYPE load_balancer_active_connections gauge\n"
@@ -154,5 +257,57 @@ func (m *Metrics) GetPrometheusMetrics() string {
 		metrics += "load_balancer_health_check_failures{backend=\"" + backend + "\"} " + strconv.FormatInt(count, 10) + "\n"
 	}
 
+	// Backend health
+	metrics += "# HELP load_balancer_backend_healthy Whether a backend is healthy (1) or not (0)\n"
+	metrics += "# TYPE load_balancer_backend_healthy gauge\n"
+	for backend, healthy := range m.backendHealthy {
+		metrics += "load_balancer_backend_healthy{backend=\"" + backend + "\"} " + strconv.FormatInt(healthy, 10) + "\n"
+	}
+
+	// Backend health transitions
+	metrics += "# HELP load_balancer_backend_health_transitions Number of health state changes per backend\n"
+	metrics += "# TYPE load_balancer_backend_health_transitions counter\n"
+	for backend, count := range m.healthTransitions {
+		metrics += "load_balancer_backend_health_transitions{backend=\"" + backend + "\"} " + strconv.FormatInt(count, 10) + "\n"
+	}
+
+	// Backend health last change
+	metrics += "# HELP load_balancer_backend_health_last_change_seconds Unix time of the last health state change per backend\n"
+	metrics += "# TYPE load_balancer_backend_health_last_change_seconds gauge\n"
+	for backend, timestamp := range m.healthLastChange {
+		metrics += "load_balancer_backend_health_last_change_seconds{backend=\"" + backend + "\"} " + strconv.FormatInt(timestamp, 10) + "\n"
+	}
+
+	// Outlier ejections
+	metrics += "# HELP load_balancer_outlier_ejections Number of outlier detection ejections per backend\n"
+	metrics += "# TYPE load_balancer_outlier_ejections counter\n"
+	for backend, count := range m.outlierEjections {
+		metrics += "load_balancer_outlier_ejections{backend=\"" + backend + "\"} " + strconv.FormatInt(count, 10) + "\n"
+	}
+
+	// Circuit breaker state
+	metrics += "# HELP load_balancer_circuit_breaker_state Circuit breaker state per backend: closed (0), open (1) or half-open (2)\n"
+	metrics += "# TYPE load_balancer_circuit_breaker_state gauge\n"
+	for backend, state := range m.circuitState {
+		metrics += "load_balancer_circuit_breaker_state{backend=\"" + backend + "\"} " + strconv.FormatInt(state, 10) + "\n"
+	}
+
+	// Circuit breaker transitions
+	metrics += "# HELP load_balancer_circuit_breaker_transitions Number of circuit breaker state changes per backend\n"
+	metrics += "# TYPE load_balancer_circuit_breaker_transitions counter\n"
+	for backend, count := range m.circuitTransitions {
+		metrics += "load_balancer_circuit_breaker_transitions{backend=\"" + backend + "\"} " + strconv.FormatInt(count, 10) + "\n"
+	}
+
+	// Request attempts
+	metrics += "# HELP load_balancer_request_attempts Number of backend attempts per request\n"
+	metrics += "# TYPE load_balancer_request_attempts histogram\n"
+	for i, bound := range attemptBuckets {
+		metrics += "load_balancer_request_attempts_bucket{le=\"" + strconv.Itoa(bound) + "\"} " + strconv.FormatInt(m.requestAttempts[i], 10) + "\n"
+	}
+	metrics += "load_balancer_request_attempts_bucket{le=\"+Inf\"} " + strconv.FormatInt(m.requestAttemptsCount, 10) + "\n"
+	metrics += "load_balancer_request_attempts_sum " + strconv.FormatInt(m.requestAttemptsSum, 10) + "\n"
+	metrics += "load_balancer_request_attempts_count " + strconv.FormatInt(m.requestAttemptsCount, 10) + "\n"
+
 	return metrics
 }
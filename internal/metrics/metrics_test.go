@@ -1,6 +1,7 @@
 package metrics
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -104,3 +105,28 @@ func TestMetrics(t *testing.T) {
 		}
 	})
 }
+
+func TestRequestAttempts(t *testing.T) {
+	m := New()
+	m.RecordRequestAttempts(1)
+	m.RecordRequestAttempts(1)
+	m.RecordRequestAttempts(3)
+	m.RecordRequestAttempts(7)
+	m.RecordRequestAttempts(0) // no backend was tried
+
+	output := m.GetPrometheusMetrics()
+	expect := []string{
+		`load_balancer_request_attempts_bucket{le="1"} 2`,
+		`load_balancer_request_attempts_bucket{le="2"} 2`,
+		`load_balancer_request_attempts_bucket{le="3"} 3`,
+		`load_balancer_request_attempts_bucket{le="5"} 3`,
+		`load_balancer_request_attempts_bucket{le="+Inf"} 4`,
+		`load_balancer_request_attempts_sum 12`,
+		`load_balancer_request_attempts_count 4`,
+	}
+	for _, line := range expect {
+		if !strings.Contains(output, line+"\n") {
+			t.Errorf("Expected metrics output to contain %q", line)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package outlier
+
+import (
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"load-balancer/internal/backend"
+	"load-balancer/internal/metrics"
+)
+
+// Config holds the outlier detection configuration. Zero thresholds disable
+// the corresponding detection.
+type Config struct {
+	// Interval is how often success rates and latencies are compared and
+	// ejections expire
+	Interval time.Duration
+	// Consecutive5xx ejects a backend after this many 5xx responses or
+	// connection failures in a row
+	Consecutive5xx int
+	// ConsecutiveGatewayErrors ejects a backend after this many 502, 503,
+	// 504 responses or connection failures in a row
+	ConsecutiveGatewayErrors int
+	// BaseEjectionTime is doubled for each consecutive ejection of a backend
+	BaseEjectionTime time.Duration
+	// MaxEjectionTime caps the ejection time
+	MaxEjectionTime time.Duration
+	// MaxEjectionPercent caps the share of backends ejected at once; one
+	// backend can always be ejected
+	MaxEjectionPercent int
+	// SuccessRateMinHosts is the number of backends with enough requests in
+	// an interval needed to compare success rates and latencies
+	SuccessRateMinHosts int
+	// SuccessRateRequestVolume is the number of requests a backend needs in
+	// an interval to be compared
+	SuccessRateRequestVolume int
+	// SuccessRateStdevFactor ejects backends whose success rate is below the
+	// pool mean by more than this many standard deviations
+	SuccessRateStdevFactor float64
+	// LatencyFactor ejects backends whose mean latency exceeds the pool
+	// median by this factor
+	LatencyFactor float64
+}
+
+// DefaultConfig returns the default outlier detection configuration
+func DefaultConfig() Config {
+	return Config{
+		Interval:                 10 * time.Second,
+		Consecutive5xx:           5,
+		ConsecutiveGatewayErrors: 0,
+		BaseEjectionTime:         30 * time.Second,
+		MaxEjectionTime:          5 * time.Minute,
+		MaxEjectionPercent:       10,
+		SuccessRateMinHosts:      5,
+		SuccessRateRequestVolume: 100,
+		SuccessRateStdevFactor:   1.9,
+		LatencyFactor:            0,
+	}
+}
+
+// host holds the outcomes observed for a backend
+type host struct {
+	backend *backend.Backend
+
+	consecutive5xx     int
+	consecutiveGateway int
+
+	// Counters for the current interval
+	requests  int
+	successes int
+	latency   time.Duration
+
+	ejectedUntil time.Time
+	ejections    int
+}
+
+// Detector ejects backends whose live traffic fails or slows down compared to
+// the rest of the pool. Ejected backends are reported unavailable by
+// Backend.IsAvailable until their ejection time passes.
+type Detector struct {
+	config  Config
+	metrics *metrics.Metrics
+
+	mu    sync.Mutex
+	hosts map[string]*host
+	now   func() time.Time
+	stop  chan struct{}
+	once  sync.Once
+}
+
+// New creates a new outlier detector
+func New(config Config) *Detector {
+	return &Detector{
+		config: config,
+		hosts:  make(map[string]*host),
+		now:    time.Now,
+		stop:   make(chan struct{}),
+	}
+}
+
+// SetMetrics sets the metrics that record ejections
+func (d *Detector) SetMetrics(m *metrics.Metrics) {
+	d.metrics = m
+}
+
+// AddBackend adds a backend to be watched
+func (d *Detector) AddBackend(b *backend.Backend) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.hosts[b.ID()] = &host{backend: b}
+}
+
+// RemoveBackend stops watching a backend
+func (d *Detector) RemoveBackend(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.hosts, id)
+}
+
+// Start begins evaluating the pool every interval
+func (d *Detector) Start() {
+	go func() {
+		ticker := time.NewTicker(d.config.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				d.evaluate()
+			case <-d.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops evaluating the pool
+func (d *Detector) Stop() {
+	d.once.Do(func() { close(d.stop) })
+}
+
+// Record records the outcome of a proxied request. A status of zero means the
+// backend could not be reached.
+func (d *Detector) Record(id string, status int, latency time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	h, ok := d.hosts[id]
+	if !ok {
+		return
+	}
+
+	h.requests++
+	h.latency += latency
+	if status != 0 && status < 500 {
+		h.successes++
+		h.consecutive5xx = 0
+		h.consecutiveGateway = 0
+		return
+	}
+
+	h.consecutive5xx++
+	if isGatewayError(status) {
+		h.consecutiveGateway++
+	} else {
+		h.consecutiveGateway = 0
+	}
+
+	switch {
+	case d.config.Consecutive5xx > 0 && h.consecutive5xx >= d.config.Consecutive5xx:
+		d.eject(h, "consecutive 5xx")
+	case d.config.ConsecutiveGatewayErrors > 0 && h.consecutiveGateway >= d.config.ConsecutiveGatewayErrors:
+		d.eject(h, "consecutive gateway errors")
+	}
+}
+
+// isGatewayError reports whether a status means the backend is unreachable
+func isGatewayError(status int) bool {
+	return status == 0 || status == 502 || status == 503 || status == 504
+}
+
+// eject removes a backend from rotation for its ejection time, unless too
+// many backends are already ejected. The caller must hold d.mu.
+func (d *Detector) eject(h *host, reason string) {
+	if h.backend.IsEjected() || !d.canEject() {
+		return
+	}
+
+	// Each consecutive ejection doubles the ejection time
+	ejection := d.config.BaseEjectionTime << min(h.ejections, 16)
+	if d.config.MaxEjectionTime > 0 && ejection > d.config.MaxEjectionTime {
+		ejection = d.config.MaxEjectionTime
+	}
+	h.ejections++
+	h.ejectedUntil = d.now().Add(ejection)
+	h.consecutive5xx = 0
+	h.consecutiveGateway = 0
+	h.backend.SetEjected(true)
+
+	log.Printf("Ejected backend %s for %v: %s", h.backend.ID(), ejection, reason)
+	if d.metrics != nil {
+		d.metrics.IncrementOutlierEjections(h.backend.ID())
+	}
+}
+
+// canEject reports whether another backend may be ejected. The caller must
+// hold d.mu.
+func (d *Detector) canEject() bool {
+	ejected := 0
+	for _, h := range d.hosts {
+		if h.backend.IsEjected() {
+			ejected++
+		}
+	}
+	return ejected == 0 || (ejected+1)*100 <= d.config.MaxEjectionPercent*len(d.hosts)
+}
+
+// evaluate returns backends whose ejection time has passed, ejects success
+// rate and latency outliers and starts a new interval
+func (d *Detector) evaluate() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	for _, h := range d.hosts {
+		if h.backend.IsEjected() {
+			if !now.Before(h.ejectedUntil) {
+				h.backend.SetEjected(false)
+				log.Printf("Returned backend %s from ejection", h.backend.ID())
+			}
+		} else if h.ejections > 0 {
+			// Backends that stay in rotation earn back shorter ejections
+			h.ejections--
+		}
+	}
+
+	d.ejectSuccessRateOutliers()
+	d.ejectLatencyOutliers()
+
+	for _, h := range d.hosts {
+		h.requests = 0
+		h.successes = 0
+		h.latency = 0
+	}
+}
+
+// candidates returns the backends in rotation with enough requests this
+// interval to be compared, or nil if there are too few of them. The caller
+// must hold d.mu.
+func (d *Detector) candidates() []*host {
+	var hosts []*host
+	for _, h := range d.hosts {
+		if !h.backend.IsEjected() && h.requests >= max(d.config.SuccessRateRequestVolume, 1) {
+			hosts = append(hosts, h)
+		}
+	}
+	if len(hosts) < max(d.config.SuccessRateMinHosts, 1) {
+		return nil
+	}
+	return hosts
+}
+
+// ejectSuccessRateOutliers ejects backends whose success rate is further
+// below the pool mean than the configured number of standard deviations. The
+// caller must hold d.mu.
+func (d *Detector) ejectSuccessRateOutliers() {
+	if d.config.SuccessRateStdevFactor <= 0 {
+		return
+	}
+	hosts := d.candidates()
+	if hosts == nil {
+		return
+	}
+
+	rates := make([]float64, len(hosts))
+	var mean float64
+	for i, h := range hosts {
+		rates[i] = float64(h.successes) / float64(h.requests)
+		mean += rates[i]
+	}
+	mean /= float64(len(hosts))
+
+	var variance float64
+	for _, rate := range rates {
+		variance += (rate - mean) * (rate - mean)
+	}
+	stdev := math.Sqrt(variance / float64(len(hosts)))
+
+	threshold := mean - d.config.SuccessRateStdevFactor*stdev
+	for i, h := range hosts {
+		if rates[i] < threshold {
+			d.eject(h, "success rate outlier")
+		}
+	}
+}
+
+// ejectLatencyOutliers ejects backends whose mean latency exceeds the pool
+// median by the configured factor. The caller must hold d.mu.
+func (d *Detector) ejectLatencyOutliers() {
+	if d.config.LatencyFactor <= 0 {
+		return
+	}
+	hosts := d.candidates()
+	if hosts == nil {
+		return
+	}
+
+	means := make([]time.Duration, len(hosts))
+	for i, h := range hosts {
+		means[i] = h.latency / time.Duration(h.requests)
+	}
+	sorted := append([]time.Duration(nil), means...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	median := sorted[len(sorted)/2]
+
+	threshold := time.Duration(float64(median) * d.config.LatencyFactor)
+	for i, h := range hosts {
+		if means[i] > threshold {
+			d.eject(h, "latency outlier")
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package outlier
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"load-balancer/internal/backend"
+)
+
+// newTestDetector creates a detector over n backends with a controllable clock
+func newTestDetector(config Config, n int) (*Detector, []*backend.Backend, *time.Time) {
+	d := New(config)
+	now := time.Unix(0, 0)
+	d.now = func() time.Time { return now }
+
+	backends := make([]*backend.Backend, n)
+	for i := range backends {
+		backends[i] = backend.New(fmt.Sprintf("backend%d", i+1), "http://localhost", 1)
+		d.AddBackend(backends[i])
+	}
+	return d, backends, &now
+}
+
+func TestConsecutiveErrors(t *testing.T) {
+	config := Config{
+		Consecutive5xx:     3,
+		BaseEjectionTime:   30 * time.Second,
+		MaxEjectionTime:    time.Minute,
+		MaxEjectionPercent: 100,
+	}
+	d, backends, now := newTestDetector(config, 2)
+	b := backends[0]
+
+	// A success resets the count
+	d.Record(b.ID(), 500, time.Millisecond)
+	d.Record(b.ID(), 500, time.Millisecond)
+	d.Record(b.ID(), 200, time.Millisecond)
+	d.Record(b.ID(), 500, time.Millisecond)
+	if b.IsEjected() {
+		t.Fatal("Backend ejected before reaching consecutive errors")
+	}
+	d.Record(b.ID(), 503, time.Millisecond)
+	d.Record(b.ID(), 0, time.Millisecond)
+	if !b.IsEjected() || b.IsAvailable() {
+		t.Fatal("Expected backend to be ejected and unavailable")
+	}
+
+	// The backend returns after its ejection time
+	*now = now.Add(29 * time.Second)
+	d.evaluate()
+	if !b.IsEjected() {
+		t.Fatal("Backend returned before its ejection time")
+	}
+	*now = now.Add(time.Second)
+	d.evaluate()
+	if b.IsEjected() {
+		t.Fatal("Expected backend to return after its ejection time")
+	}
+
+	// A second ejection lasts twice as long, up to the maximum
+	for _, want := range []time.Duration{60 * time.Second, 60 * time.Second} {
+		for i := 0; i < 3; i++ {
+			d.Record(b.ID(), 500, time.Millisecond)
+		}
+		*now = now.Add(want - time.Second)
+		d.evaluate()
+		if !b.IsEjected() {
+			t.Fatalf("Backend returned before %v", want)
+		}
+		*now = now.Add(time.Second)
+		d.evaluate()
+		if b.IsEjected() {
+			t.Fatalf("Expected backend to return after %v", want)
+		}
+	}
+}
+
+func TestConsecutiveGatewayErrors(t *testing.T) {
+	config := Config{
+		ConsecutiveGatewayErrors: 2,
+		BaseEjectionTime:         30 * time.Second,
+		MaxEjectionPercent:       100,
+	}
+	d, backends, _ := newTestDetector(config, 2)
+	b := backends[0]
+
+	// Other 5xx responses break the run of gateway errors
+	d.Record(b.ID(), 502, time.Millisecond)
+	d.Record(b.ID(), 500, time.Millisecond)
+	d.Record(b.ID(), 504, time.Millisecond)
+	if b.IsEjected() {
+		t.Fatal("Backend ejected without consecutive gateway errors")
+	}
+	d.Record(b.ID(), 0, time.Millisecond)
+	if !b.IsEjected() {
+		t.Fatal("Expected backend to be ejected")
+	}
+}
+
+func TestMaxEjectionPercent(t *testing.T) {
+	config := Config{
+		Consecutive5xx:     1,
+		BaseEjectionTime:   30 * time.Second,
+		MaxEjectionPercent: 50,
+	}
+	d, backends, _ := newTestDetector(config, 4)
+
+	for _, b := range backends {
+		d.Record(b.ID(), 500, time.Millisecond)
+	}
+
+	ejected := 0
+	for _, b := range backends {
+		if b.IsEjected() {
+			ejected++
+		}
+	}
+	if ejected != 2 {
+		t.Errorf("Ejected %d backends, want 2", ejected)
+	}
+}
+
+func TestSuccessRateOutlier(t *testing.T) {
+	config := Config{
+		BaseEjectionTime:         30 * time.Second,
+		MaxEjectionPercent:       100,
+		SuccessRateMinHosts:      3,
+		SuccessRateRequestVolume: 10,
+		SuccessRateStdevFactor:   1.5,
+	}
+	d, backends, _ := newTestDetector(config, 5)
+
+	// backend1 fails half of its requests, spread out so no run ejects it
+	for i := 0; i < 20; i++ {
+		for j, b := range backends {
+			status := 200
+			if j == 0 && i%2 == 0 {
+				status = 500
+			}
+			d.Record(b.ID(), status, time.Millisecond)
+		}
+	}
+	d.evaluate()
+	for i, b := range backends {
+		if b.IsEjected() != (i == 0) {
+			t.Errorf("%s ejected = %v, want %v", b.ID(), b.IsEjected(), i == 0)
+		}
+	}
+}
+
+func TestLatencyOutlier(t *testing.T) {
+	config := Config{
+		BaseEjectionTime:         30 * time.Second,
+		MaxEjectionPercent:       100,
+		SuccessRateMinHosts:      3,
+		SuccessRateRequestVolume: 10,
+		LatencyFactor:            3,
+	}
+	d, backends, _ := newTestDetector(config, 4)
+
+	latencies := []time.Duration{10, 12, 11, 50}
+	for i := 0; i < 10; i++ {
+		for j, b := range backends {
+			d.Record(b.ID(), 200, latencies[j]*time.Millisecond)
+		}
+	}
+
+	d.evaluate()
+	for i, b := range backends {
+		if b.IsEjected() != (i == 3) {
+			t.Errorf("%s ejected = %v, want %v", b.ID(), b.IsEjected(), i == 3)
+		}
+	}
+}
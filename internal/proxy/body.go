@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+)
+
+// errBodyTooLarge is returned when a request body exceeds the size that may be
+// buffered for retries
+var errBodyTooLarge = errors.New("request body too large to buffer")
+
+// bufferedBody holds a request body so it can be replayed across retries.
+// Bodies up to the memory limit are kept in memory, larger ones are spilled
+// to a temporary file.
+type bufferedBody struct {
+	mem  []byte
+	file *os.File
+	size int64
+}
+
+// bufferBody reads body into memory, spilling to a temporary file once more
+// than memLimit bytes have been read. For bodies larger than maxSize it stops
+// reading and returns errBodyTooLarge along with the part read so far; the
+// rest is left in body.
+func bufferBody(body io.Reader, memLimit, maxSize int64) (*bufferedBody, error) {
+	mem, err := io.ReadAll(io.LimitReader(body, memLimit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(mem)) <= memLimit {
+		return &bufferedBody{mem: mem, size: int64(len(mem))}, nil
+	}
+	if int64(len(mem)) > maxSize {
+		return &bufferedBody{mem: mem, size: int64(len(mem))}, errBodyTooLarge
+	}
+
+	file, err := os.CreateTemp("", "lb-body-*")
+	if err != nil {
+		return nil, err
+	}
+	bb := &bufferedBody{file: file}
+
+	n, err := io.Copy(file, io.LimitReader(io.MultiReader(bytes.NewReader(mem), body), maxSize+1))
+	if err != nil {
+		bb.Close()
+		return nil, err
+	}
+	bb.size = n
+	if n > maxSize {
+		return bb, errBodyTooLarge
+	}
+
+	return bb, nil
+}
+
+// Reader returns a new reader positioned at the start of the body
+func (b *bufferedBody) Reader() (io.ReadCloser, error) {
+	if b.file != nil {
+		return io.NopCloser(io.NewSectionReader(b.file, 0, b.size)), nil
+	}
+	return io.NopCloser(bytes.NewReader(b.mem)), nil
+}
+
+// Close releases the buffered body and removes any temporary file
+func (b *bufferedBody) Close() error {
+	if b.file == nil {
+		return nil
+	}
+	name := b.file.Name()
+	b.file.Close()
+	return os.Remove(name)
+}
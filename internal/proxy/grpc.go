@@ -0,0 +1,48 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// gRPC status codes that indicate the backend, rather than the call, failed
+const (
+	grpcDeadlineExceeded = 4
+	grpcInternal         = 13
+	grpcUnavailable      = 14
+)
+
+// isGRPC reports whether a response carries a gRPC call
+func isGRPC(resp *http.Response) bool {
+	return strings.HasPrefix(resp.Header.Get("Content-Type"), "application/grpc")
+}
+
+// grpcStatus returns the grpc-status of a completed call. Trailers-only
+// responses carry the status in the header instead of the trailer.
+func grpcStatus(resp *http.Response) (int, bool) {
+	value := resp.Trailer.Get("Grpc-Status")
+	if value == "" {
+		value = resp.Header.Get("Grpc-Status")
+	}
+	code, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, false
+	}
+	return code, true
+}
+
+// isGRPCFailure reports whether a completed gRPC call should count as a
+// backend failure. Calls that end without a status were cut off.
+func isGRPCFailure(resp *http.Response) bool {
+	code, ok := grpcStatus(resp)
+	if !ok {
+		return true
+	}
+	switch code {
+	case grpcDeadlineExceeded, grpcInternal, grpcUnavailable:
+		return true
+	default:
+		return false
+	}
+}
@@ -1,15 +1,22 @@
 package proxy
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"sync/atomic"
 	"time"
 
 	"load-balancer/internal/backend"
 	"load-balancer/internal/balancer"
+	"load-balancer/internal/circuitbreaker"
 	"load-balancer/internal/metrics"
+	"load-balancer/internal/outlier"
 	"load-balancer/internal/retry"
 	"load-balancer/internal/session"
 )
@@ -19,25 +26,113 @@ type Proxy struct {
 	balancer balancer.Balancer
 	metrics  *metrics.Metrics
 	session  *session.Manager
-	client   *http.Client
+	outliers *outlier.Detector
+	// transports send requests to backends per protocol, without following redirects
+	transports   map[backend.Protocol]*http.Transport
+	retry        *retry.Config
+	routes       []Route
+	defaultRoute Route
+	// trustedProxies are the networks whose forwarded headers are kept
+	trustedProxies []*net.IPNet
+	// maxBodyBuffer is the request body size kept in memory for retries
+	maxBodyBuffer int64
+	// maxBodySpill is the largest request body buffered for retries
+	maxBodySpill int64
 }
 
+const (
+	// DefaultMaxBodyBuffer is the default request body size kept in memory for retries
+	DefaultMaxBodyBuffer = 1 << 20
+	// DefaultMaxBodySpill is the default largest request body buffered for retries
+	DefaultMaxBodySpill = 64 << 20
+	// DefaultIdleTimeout is the default time allowed without data from a backend
+	DefaultIdleTimeout = 60 * time.Second
+)
+
 // New creates a new proxy
 func New(m *metrics.Metrics) *Proxy {
+	retryConfig := retry.DefaultConfig()
 	return &Proxy{
-		metrics: m,
-		client: &http.Client{
-			Timeout: 30 * time.Second,
+		metrics:       m,
+		retry:         &retryConfig,
+		maxBodyBuffer: DefaultMaxBodyBuffer,
+		maxBodySpill:  DefaultMaxBodySpill,
+		transports:    newTransports(),
+		defaultRoute: Route{
+			IdleTimeout: DefaultIdleTimeout,
 		},
 	}
 }
 
+// newTransports creates a transport for each backend protocol
+func newTransports() map[backend.Protocol]*http.Transport {
+	base := http.DefaultTransport.(*http.Transport)
+
+	http1 := base.Clone()
+	http1.ForceAttemptHTTP2 = false
+	http1.Protocols = new(http.Protocols)
+	http1.Protocols.SetHTTP1(true)
+
+	h2 := base.Clone()
+	h2.Protocols = new(http.Protocols)
+	h2.Protocols.SetHTTP2(true)
+
+	h2c := base.Clone()
+	h2c.Protocols = new(http.Protocols)
+	h2c.Protocols.SetUnencryptedHTTP2(true)
+
+	return map[backend.Protocol]*http.Transport{
+		backend.ProtocolAuto:  base.Clone(),
+		backend.ProtocolHTTP1: http1,
+		backend.ProtocolHTTP2: h2,
+		backend.ProtocolH2C:   h2c,
+	}
+}
+
+// transportFor returns the transport for a backend. Upgrades are only
+// possible over HTTP/1.1.
+func (p *Proxy) transportFor(b *backend.Backend, upgrade bool) *http.Transport {
+	if upgrade {
+		return p.transports[backend.ProtocolHTTP1]
+	}
+	if transport, ok := p.transports[b.Protocol()]; ok {
+		return transport
+	}
+	return p.transports[backend.ProtocolAuto]
+}
+
 // SetBalancer sets the load balancer
 func (p *Proxy) SetBalancer(b balancer.Balancer) {
 	p.balancer = b
 }
 
-// SetSessionManager sets the session manager
+// SetRetryConfig sets the per-request retry configuration. Each retry is sent
+// to a backend that has not been tried yet for the request.
+func (p *Proxy) SetRetryConfig(config *retry.Config) {
+	p.retry = config
+}
+
+// SetMaxBodyBuffer sets the request body size kept in memory for retries.
+// Larger bodies are buffered in a temporary file.
+func (p *Proxy) SetMaxBodyBuffer(size int64) {
+	p.maxBodyBuffer = size
+}
+
+// SetMaxBodySpill sets the largest request body buffered for retries. Larger
+// requests are sent once, without retries.
+func (p *Proxy) SetMaxBodySpill(size int64) {
+	p.maxBodySpill = size
+}
+
+// SetOutlierDetector sets the detector that ejects backends based on the
+// outcomes of proxied requests
+func (p *Proxy) SetOutlierDetector(d *outlier.Detector) {
+	p.outliers = d
+}
+
+// SetSessionManager sets the session manager used to record sticky sessions.
+// Routing to an existing session is done by wrapping the balancer with
+// balancer.NewSticky.
 func (p *Proxy) SetSessionManager(s *session.Manager) {
 	p.session = s
 }
@@ -54,41 +149,117 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Increment total requests
 	p.metrics.IncrementTotalRequests()
 
-	// Check for existing session
-	var backendID string
-	if p.session != nil {
-		backendID = p.session.GetBackendID(r)
+	// Only idempotent requests are retried unless the route allows otherwise
+	route := p.route(r)
+	retryConfig := *p.retry
+	if !isIdempotent(r.Method) && !route.RetryNonIdempotent {
+		retryConfig.MaxRetries = 0
 	}
 
-	// Get backend from balancer
-	var backend *backend.Backend
-	var err error
-	if backendID != "" {
-		backend, err = p.balancer.GetBackend(backendID)
-		if err != nil {
-			backend = nil
+	// Buffer the body so every attempt can replay it. Bodies too large to
+	// buffer are streamed to a single backend without retries.
+	if retryConfig.MaxRetries > 0 && r.Body != nil && r.Body != http.NoBody {
+		if r.ContentLength > p.maxBodySpill {
+			retryConfig.MaxRetries = 0
+		} else {
+			body, err := bufferBody(r.Body, p.maxBodyBuffer, p.maxBodySpill)
+			if err != nil && !errors.Is(err, errBodyTooLarge) {
+				p.metrics.IncrementFailedRequests()
+				http.Error(w, "Failed to read request body", http.StatusBadRequest)
+				return
+			}
+			defer body.Close()
+
+			rest := r.Body
+			r = r.Clone(r.Context())
+			if err != nil {
+				// Send the part already read followed by the rest
+				head, _ := body.Reader()
+				r.Body = struct {
+					io.Reader
+					io.Closer
+				}{io.MultiReader(head, rest), rest}
+				retryConfig.MaxRetries = 0
+			} else {
+				r.ContentLength = body.size
+				r.GetBody = body.Reader
+			}
 		}
 	}
-	if backend == nil {
-		backend, err = p.balancer.Next()
-		if err != nil {
-			p.metrics.IncrementFailedRequests()
-			http.Error(w, "No available backends", http.StatusServiceUnavailable)
-			return
+
+	// Each attempt asks the balancer for a backend that has not been tried yet.
+	// The backend for a retry is picked before waiting for it, so a request
+	// that has run out of backends ends at once.
+	routing := balancer.NewRequest(r)
+	var served, next *backend.Backend
+	var nextPermit *circuitbreaker.Permit
+	// kept is the last backend response discarded for a retry
+	var kept *keptResponse
+	attempts := 0
+	defer func() { p.metrics.RecordRequestAttempts(attempts) }()
+	// Give back the permit of a retry that was never sent
+	defer func() {
+		if nextPermit != nil {
+			nextPermit.Release()
 		}
-	}
+	}()
+	err := retry.Do(r.Context(), &retryConfig, func() error {
+		b, permit := next, nextPermit
+		next = nil
+		if b == nil {
+			var err error
+			if b, permit, err = p.nextBackend(routing); err != nil {
+				return err
+			}
+		}
+		attempts++
 
-	// Increment backend requests
-	p.metrics.IncrementBackendRequests(backend.ID())
-	log.Printf("Incoming request %s to backend %s", r.URL.Path, backend.ID())
-	// Forward request to backend
-	err = p.forwardRequest(w, r, backend)
-	if err != nil {
-		p.metrics.IncrementBackendFailures(backend.ID())
-		switch err {
-		case ErrBackendUnavailable:
+		// Increment backend requests
+		p.metrics.IncrementBackendRequests(b.ID())
+		log.Printf("Incoming request %s to backend %s", r.URL.Path, b.ID())
+
+		// Forward request to backend
+		// The last allowed attempt passes any response through to the client
+		final := attempts > retryConfig.MaxRetries
+		if err := p.forwardRequest(w, r, b, permit, route, final); err != nil {
+			p.metrics.IncrementBackendFailures(b.ID())
+			errors.As(err, &kept)
+
+			// Without a backend left to retry on, the failure is final
+			var retryable *retry.RetryableError
+			if !final && errors.As(err, &retryable) {
+				var nextErr error
+				if next, nextPermit, nextErr = p.nextBackend(routing); nextErr != nil {
+					return retryable.Err
+				}
+			}
+			return err
+		}
+
+		served = b
+		return nil
+	})
+	if errors.Is(err, retry.ErrBudgetExhausted) {
+		p.metrics.IncrementRetriesDenied()
+	}
+	switch {
+	case err == nil:
+	case kept != nil:
+		// Pass the last backend response through rather than an error of our own
+		if err := writeResponse(w, kept.resp, kept.resp.Body, flushIntervalFor(kept.resp, route)); err != nil {
+			log.Printf("Failed to copy response body from backend %s: %v", kept.backend.ID(), err)
+		}
+		served = kept.backend
+	default:
+		p.metrics.IncrementFailedRequests()
+		switch {
+		case errors.Is(err, balancer.ErrNoBackends), errors.Is(err, balancer.ErrNoHealthyBackends):
+			http.Error(w, "No available backends", http.StatusServiceUnavailable)
+		case errors.Is(err, ErrBackendTimeout):
+			http.Error(w, "Backend timeout", http.StatusGatewayTimeout)
+		case errors.Is(err, ErrBackendUnavailable):
 			http.Error(w, "Backend unavailable", http.StatusServiceUnavailable)
-		case ErrBackendError:
+		case errors.Is(err, ErrBackendError):
 			http.Error(w, "Backend error", http.StatusBadGateway)
 		default:
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -98,81 +269,248 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// Set session if enabled
 	if p.session != nil {
-		p.session.SetBackendID(r, w, backend.ID())
+		p.session.SetBackendID(r, w, served.ID())
+	}
+}
+
+// nextBackend returns the next backend for the request along with a circuit
+// breaker permit to send it. Backends whose circuit admits no more requests
+// are skipped.
+func (p *Proxy) nextBackend(routing *balancer.Request) (*backend.Backend, *circuitbreaker.Permit, error) {
+	for {
+		b, err := p.balancer.Next(routing)
+		if err != nil {
+			return nil, nil, err
+		}
+		routing.Exclude(b.ID())
+		if permit, ok := b.GetCircuitBreaker().Acquire(); ok {
+			return b, permit, nil
+		}
 	}
 }
 
-// forwardRequest forwards a request to a backend
-func (p *Proxy) forwardRequest(w http.ResponseWriter, r *http.Request, b *backend.Backend) error {
+// forwardRequest makes a single attempt to forward a request to a backend.
+// Failures the retry policy allows are returned as retryable errors unless
+// this is the final attempt, and the outcome is recorded with the backend's
+// circuit breaker permit.
+func (p *Proxy) forwardRequest(w http.ResponseWriter, r *http.Request, b *backend.Backend, permit *circuitbreaker.Permit, route Route, final bool) error {
+	// Give the permit back if the attempt ends without an outcome
+	defer permit.Release()
+
 	// Increment active connections
+	b.IncrementConnections()
+	defer b.DecrementConnections()
 	p.metrics.IncrementActiveConnections(b.ID())
 	defer p.metrics.DecrementActiveConnections(b.ID())
 
+	// Replay buffered bodies from the start on every attempt
+	body := r.Body
+	if r.GetBody != nil {
+		var err error
+		if body, err = r.GetBody(); err != nil {
+			return err
+		}
+	}
+
+	// Upgrade requests open long-lived tunnels, so route timeouts don't apply
+	upgrade := upgradeType(r.Header)
+
+	// Bound the attempt by the route's total and idle timeouts
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	if route.Timeout > 0 && upgrade == "" {
+		ctx, cancel = context.WithTimeout(ctx, route.Timeout)
+		defer cancel()
+	}
+	var idle *time.Timer
+	var idleExpired atomic.Bool
+	if route.IdleTimeout > 0 && upgrade == "" {
+		idle = time.AfterFunc(route.IdleTimeout, func() {
+			idleExpired.Store(true)
+			cancel()
+		})
+		defer idle.Stop()
+	}
+
 	// Create request to backend
-	req, err := http.NewRequest(r.Method, b.URL().String()+r.URL.Path, r.Body)
+	req, err := http.NewRequestWithContext(ctx, r.Method, targetURL(b.URL(), r).String(), body)
 	if err != nil {
 		return err
 	}
+	req.ContentLength = r.ContentLength
+	req.GetBody = r.GetBody
+
+	// Copy headers and set the forwarded headers
+	p.rewriteRequest(req, r, b)
 
-	// Copy headers
-	for k, v := range r.Header {
-		req.Header[k] = v
+	// Upgrade requests keep their upgrade headers
+	if upgrade != "" {
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", upgrade)
 	}
 
-	// Set host header
-	req.Host = r.Host
+	start := time.Now()
+	resp, err := p.transportFor(b, upgrade != "").RoundTrip(req)
+	if err != nil {
+		// Record failure in circuit breaker and outlier detection
+		permit.Record(false, time.Since(start))
+		p.recordOutcome(b, 0, time.Since(start))
+		if idleExpired.Load() || errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("%w: %s: %w", ErrBackendTimeout, b.ID(), err)
+		}
+		err = fmt.Errorf("%w: %s: %w", ErrBackendUnavailable, b.ID(), err)
+		if p.retry.Policy.ShouldRetryError(err) {
+			return retry.NewRetryableError(err)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	latency := time.Since(start)
+	p.observeLatency(b, latency)
 
-	// Create retry config
-	retryConfig := b.GetRetryConfig()
+	// Record the outcome in circuit breaker and outlier detection. gRPC calls
+	// report failures in their trailers, so those are recorded once the body
+	// has been copied.
+	grpc := isGRPC(resp)
+	if !grpc {
+		permit.Record(resp.StatusCode < 500, latency)
+		p.recordOutcome(b, resp.StatusCode, latency)
+	}
 
-	// Execute request with retries
-	var resp *http.Response
-	err = retry.Do(r.Context(), retryConfig, func() error {
-		var err error
-		resp, err = p.client.Do(req)
+	// Hand switched protocols over to the tunnel
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		return p.handleUpgrade(w, r, resp, b)
+	}
+
+	// Keep responses the retry policy wants to retry, to pass through if no
+	// retry follows. Responses too large to keep are passed through now.
+	if retryable, after := p.retry.Policy.ShouldRetryResponse(resp); retryable && !final {
+		kept, err := keepResponse(resp, b)
+		if grpc && (err != nil || kept != nil) {
+			// The response won't be copied, so record the gRPC failure now
+			permit.Record(false, latency)
+			p.recordOutcome(b, resp.StatusCode, latency)
+		}
 		if err != nil {
-			return err
+			return retry.NewRetryableError(fmt.Errorf("%w: %s: %w", ErrBackendError, b.ID(), err))
 		}
-
-		// Check if response indicates failure
-		if resp.StatusCode >= 500 {
-			return errors.New("backend returned error status code")
+		if kept != nil {
+			return &retry.RetryableError{Err: kept, After: after}
 		}
+	}
 
+	// Stream the response, resetting the idle timer whenever data arrives.
+	// The status is already sent, so errors can only be logged.
+	var respBody io.Reader = resp.Body
+	if idle != nil {
+		respBody = &idleReader{r: resp.Body, reset: func() { idle.Reset(route.IdleTimeout) }}
+	}
+	if err := writeResponse(w, resp, respBody, flushIntervalFor(resp, route)); err != nil {
+		log.Printf("Failed to copy response body from backend %s: %v", b.ID(), err)
+		if grpc {
+			permit.Record(false, latency)
+			p.recordOutcome(b, http.StatusBadGateway, latency)
+		}
 		return nil
-	})
+	}
 
-	if err != nil {
-		// Record failure in circuit breaker
-		b.GetCircuitBreaker().RecordFailure()
-		return err
+	if grpc {
+		if isGRPCFailure(resp) {
+			permit.Record(false, latency)
+			p.recordOutcome(b, http.StatusServiceUnavailable, latency)
+		} else {
+			permit.Record(true, latency)
+			p.recordOutcome(b, resp.StatusCode, latency)
+		}
 	}
 
-	// Record success in circuit breaker
-	b.GetCircuitBreaker().RecordSuccess()
+	return nil
+}
 
+// writeResponse sends a backend response to the client, reading its body from
+// body
+func writeResponse(w http.ResponseWriter, resp *http.Response, body io.Reader, flushInterval time.Duration) error {
 	// Copy response headers
+	removeHopHeaders(resp.Header)
 	for k, v := range resp.Header {
 		w.Header()[k] = v
 	}
+	announceTrailers(w, resp)
 
 	// Set status code
 	w.WriteHeader(resp.StatusCode)
 
-	// Copy response body
-	if _, err := io.Copy(w, resp.Body); err != nil {
+	if err := copyResponse(w, body, flushInterval); err != nil {
 		return err
 	}
-
+	copyTrailers(w, resp)
 	return nil
 }
 
+// maxKeptResponse limits the size of a response body held while the request
+// is retried
+const maxKeptResponse = 64 << 10
+
+// keptResponse is a retryable backend response held in memory, so it can be
+// passed through if no retry follows
+type keptResponse struct {
+	resp    *http.Response
+	backend *backend.Backend
+}
+
+func (e *keptResponse) Error() string {
+	return fmt.Sprintf("%v: %s returned status %d", ErrBackendError, e.backend.ID(), e.resp.StatusCode)
+}
+
+// Unwrap returns ErrBackendError
+func (e *keptResponse) Unwrap() error {
+	return ErrBackendError
+}
+
+// keepResponse reads a response into memory. It returns nil if the body is
+// larger than maxKeptResponse, leaving the body readable from the start.
+func keepResponse(resp *http.Response, b *backend.Backend) (*keptResponse, error) {
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxKeptResponse+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxKeptResponse {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(data), resp.Body), resp.Body}
+		return nil, nil
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	return &keptResponse{resp: resp, backend: b}, nil
+}
+
+// recordOutcome feeds the status of a backend response to outlier detection;
+// a status of zero means the backend could not be reached
+func (p *Proxy) recordOutcome(b *backend.Backend, status int, latency time.Duration) {
+	if p.outliers != nil {
+		p.outliers.Record(b.ID(), status, latency)
+	}
+}
+
+// observeLatency records the time a backend took to respond and feeds it to
+// latency-aware balancers
+func (p *Proxy) observeLatency(b *backend.Backend, latency time.Duration) {
+	p.metrics.RecordBackendLatency(b.ID(), latency)
+	if o, ok := p.balancer.(balancer.Observer); ok {
+		o.Observe(b.ID(), latency)
+	}
+}
+
 // ErrBackendUnavailable is returned when the backend is not available
 var ErrBackendUnavailable = &proxyError{"backend unavailable"}
 
 // ErrBackendError is returned when the backend returns an error
 var ErrBackendError = &proxyError{"backend error"}
 
+// ErrBackendTimeout is returned when the backend exceeds the route's timeouts
+var ErrBackendTimeout = &proxyError{"backend timeout"}
+
 type proxyError struct {
 	msg string
 }
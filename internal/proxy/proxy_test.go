@@ -1,15 +1,21 @@
 package proxy
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"load-balancer/internal/backend"
 	"load-balancer/internal/balancer"
 	"load-balancer/internal/metrics"
+	"load-balancer/internal/outlier"
 	"load-balancer/internal/retry"
 	"load-balancer/internal/session"
 )
@@ -33,7 +39,10 @@ func TestProxy(t *testing.T) {
 	})
 
 	// Create balancer and add backend
-	bal := balancer.New("round-robin")
+	bal, err := balancer.New("round-robin")
+	if err != nil {
+		t.Fatalf("Failed to create balancer: %v", err)
+	}
 	bal.AddBackend("test-backend", b)
 
 	sessionConfig := session.Config{
@@ -49,7 +58,7 @@ func TestProxy(t *testing.T) {
 
 	// Create proxy and set balancer
 	proxy := New(metrics.New())
-	proxy.SetBalancer(bal)
+	proxy.SetBalancer(balancer.NewSticky(bal, sessionManager))
 	proxy.SetSessionManager(sessionManager)
 
 	fmt.Println(proxy)
@@ -95,3 +104,727 @@ func TestProxy(t *testing.T) {
 		})
 	}
 }
+
+func TestProxyRetriesOnDifferentBackend(t *testing.T) {
+	var failedHits, healthyHits int
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failedHits++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		healthyHits++
+		w.Write([]byte("ok"))
+	}))
+	defer healthy.Close()
+
+	bad := backend.New("failing", failing.URL, 1)
+	proxy := newTestProxy(t, 3, bad, backend.New("healthy", healthy.URL, 1))
+
+	// Round-robin starts with the failing backend, so the retry must move on
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	if w.Code != http.StatusOK {
+		t.Errorf("ServeHTTP() status = %v, want %v", w.Code, http.StatusOK)
+	}
+	if failedHits != 1 || healthyHits != 1 {
+		t.Errorf("Expected one attempt per backend, got failing=%d healthy=%d", failedHits, healthyHits)
+	}
+	if got := bad.GetCircuitBreaker().GetFailureCount(); got != 1 {
+		t.Errorf("Expected failure recorded against failing backend, got %d", got)
+	}
+}
+
+func TestProxyRetriesConnectError(t *testing.T) {
+	// Find a port nothing listens on
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	closed := "http://" + l.Addr().String()
+	l.Close()
+
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer healthy.Close()
+
+	proxy := newTestProxy(t, 1, backend.New("closed", closed, 1), backend.New("healthy", healthy.URL, 1))
+
+	// The refused connection is retried on the healthy backend
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	if w.Code != http.StatusOK {
+		t.Errorf("ServeHTTP() status = %v, want %v", w.Code, http.StatusOK)
+	}
+	if got := w.Body.String(); got != "ok" {
+		t.Errorf("ServeHTTP() body = %q, want %q", got, "ok")
+	}
+}
+
+func TestProxyRetriesExhausted(t *testing.T) {
+	hits := 0
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+
+	proxy := newTestProxy(t, 3, backend.New("backend1", failing.URL, 1), backend.New("backend2", failing.URL, 1))
+
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	// Each backend is tried once, then the balancer runs out of candidates
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("ServeHTTP() status = %v, want %v", w.Code, http.StatusBadGateway)
+	}
+	if hits != 2 {
+		t.Errorf("Expected 2 attempts, got %d", hits)
+	}
+}
+
+func TestProxyRetriesOutOfBackends(t *testing.T) {
+	hits := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("overloaded"))
+	}))
+	defer server.Close()
+
+	proxy := newTestProxy(t, 3, backend.New("backend1", server.URL, 1))
+
+	// With no other backend to try, the response is passed through without
+	// waiting for a retry
+	start := time.Now()
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected no retry wait, took %v", elapsed)
+	}
+	if hits != 1 {
+		t.Errorf("Expected a single attempt, got %d", hits)
+	}
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("ServeHTTP() status = %v, want %v", w.Code, http.StatusServiceUnavailable)
+	}
+	if got := w.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After = %q, want %q", got, "1")
+	}
+	if got := w.Body.String(); got != "overloaded" {
+		t.Errorf("ServeHTTP() body = %q, want %q", got, "overloaded")
+	}
+}
+
+// newTestProxy creates a proxy balancing round-robin over the backends, in
+// order, with up to maxRetries fast retries
+func newTestProxy(t *testing.T, maxRetries int, backends ...*backend.Backend) *Proxy {
+	t.Helper()
+
+	bal, err := balancer.New("round-robin")
+	if err != nil {
+		t.Fatalf("Failed to create balancer: %v", err)
+	}
+	for _, b := range backends {
+		bal.AddBackend(b.ID(), b)
+	}
+
+	proxy := New(metrics.New())
+	proxy.SetBalancer(bal)
+	proxy.SetRetryConfig(&retry.Config{
+		MaxRetries:      maxRetries,
+		InitialInterval: time.Millisecond,
+		MaxInterval:     10 * time.Millisecond,
+		Multiplier:      2,
+		Policy:          retry.DefaultPolicy(),
+	})
+	return proxy
+}
+
+// newRetryTestProxy creates a proxy over a backend that always fails with 503
+// followed by one that echoes the request body
+func newRetryTestProxy(t *testing.T) (*Proxy, *int) {
+	t.Helper()
+
+	failedHits := 0
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failedHits++
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(failing.Close)
+
+	echo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	}))
+	t.Cleanup(echo.Close)
+
+	proxy := newTestProxy(t, 1, backend.New("failing", failing.URL, 1), backend.New("echo", echo.URL, 1))
+	return proxy, &failedHits
+}
+
+func TestProxyReplaysBodyOnRetry(t *testing.T) {
+	tests := []struct {
+		name          string
+		maxBodyBuffer int64
+	}{
+		{"in memory", DefaultMaxBodyBuffer},
+		{"spilled to file", 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy, _ := newRetryTestProxy(t)
+			proxy.SetMaxBodyBuffer(tt.maxBodyBuffer)
+
+			body := "replayed request body"
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, httptest.NewRequest("PUT", "/", strings.NewReader(body)))
+
+			if w.Code != http.StatusOK {
+				t.Errorf("ServeHTTP() status = %v, want %v", w.Code, http.StatusOK)
+			}
+			if got := w.Body.String(); got != body {
+				t.Errorf("ServeHTTP() body = %q, want %q", got, body)
+			}
+		})
+	}
+}
+
+func TestProxyStreamsOversizeBody(t *testing.T) {
+	tests := []struct {
+		name          string
+		maxBodyBuffer int64
+		knownLength   bool
+	}{
+		{"known length", 4, true},
+		{"unknown length spilled to file", 4, false},
+		{"unknown length in memory", 16, false},
+	}
+
+	body := "body larger than the spill limit"
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received []string
+			failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				data, _ := io.ReadAll(r.Body)
+				received = append(received, string(data))
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}))
+			defer failing.Close()
+			echo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.Copy(w, r.Body)
+			}))
+			defer echo.Close()
+
+			proxy := newTestProxy(t, 1, backend.New("failing", failing.URL, 1), backend.New("echo", echo.URL, 1))
+			proxy.SetMaxBodyBuffer(tt.maxBodyBuffer)
+			proxy.SetMaxBodySpill(8)
+
+			req := httptest.NewRequest("PUT", "/", strings.NewReader(body))
+			if !tt.knownLength {
+				req.ContentLength = -1
+			}
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+
+			// The body is forwarded whole to the first backend and not retried
+			if w.Code != http.StatusServiceUnavailable {
+				t.Errorf("ServeHTTP() status = %v, want %v", w.Code, http.StatusServiceUnavailable)
+			}
+			if len(received) != 1 || received[0] != body {
+				t.Errorf("Backend received %q, want a single %q", received, body)
+			}
+		})
+	}
+}
+
+func TestProxyNonIdempotentRetries(t *testing.T) {
+	// POST is not retried by default, so the backend's response is passed through
+	proxy, failedHits := newRetryTestProxy(t)
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest("POST", "/orders", strings.NewReader("order")))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("ServeHTTP() status = %v, want %v", w.Code, http.StatusServiceUnavailable)
+	}
+	if *failedHits != 1 {
+		t.Errorf("Expected a single attempt, got %d", *failedHits)
+	}
+
+	// Routes can opt in to retrying non-idempotent methods
+	proxy, _ = newRetryTestProxy(t)
+	proxy.SetRoutes([]Route{{PathPrefix: "/orders", RetryNonIdempotent: true}})
+	w = httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest("POST", "/orders", strings.NewReader("order")))
+	if w.Code != http.StatusOK {
+		t.Errorf("ServeHTTP() status = %v, want %v", w.Code, http.StatusOK)
+	}
+	if got := w.Body.String(); got != "order" {
+		t.Errorf("ServeHTTP() body = %q, want %q", got, "order")
+	}
+}
+
+func TestProxyRetryAfter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	b := backend.New("backend1", server.URL, 1)
+	proxy := newTestProxy(t, 1, b)
+	permit, ok := b.GetCircuitBreaker().Acquire()
+	if !ok {
+		t.Fatal("Expected circuit breaker to admit the request")
+	}
+
+	// The kept response asks the next attempt to wait for Retry-After
+	w := httptest.NewRecorder()
+	err := proxy.forwardRequest(w, httptest.NewRequest("GET", "/", nil), b, permit, Route{}, false)
+
+	var retryableErr *retry.RetryableError
+	if !errors.As(err, &retryableErr) {
+		t.Fatalf("forwardRequest() error = %v, want a retryable error", err)
+	}
+	if retryableErr.After != time.Second {
+		t.Errorf("Retry delay = %v, want %v", retryableErr.After, time.Second)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("Expected the kept response not to be written, got %q", w.Body.String())
+	}
+}
+
+func TestProxyRetryBudget(t *testing.T) {
+	proxy, failedHits := newRetryTestProxy(t)
+	m := metrics.New()
+	proxy.metrics = m
+	proxy.retry.Budget = retry.NewBudget(retry.BudgetConfig{TTL: time.Second})
+
+	// An empty budget denies the retry, so only the failing backend is tried
+	// and its response is passed through
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("ServeHTTP() status = %v, want %v", w.Code, http.StatusServiceUnavailable)
+	}
+	if *failedHits != 1 {
+		t.Errorf("Expected a single attempt, got %d", *failedHits)
+	}
+	if got := m.GetStats()["retries_denied"].(int64); got != 1 {
+		t.Errorf("Expected 1 denied retry, got %d", got)
+	}
+}
+
+func TestProxyRetryBudgetOutOfBackends(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	// The budget holds a single retry
+	budget := retry.NewBudget(retry.BudgetConfig{MinRetriesPerSecond: 1, TTL: time.Second})
+	proxy := newTestProxy(t, 3, backend.New("backend1", server.URL, 1))
+	proxy.retry.Budget = budget
+
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	// No retry was sent, so the budget still holds it
+	if !budget.Withdraw() {
+		t.Error("Expected the budget to be unchanged when no backend was left to retry")
+	}
+	if budget.Withdraw() {
+		t.Error("Expected the budget to hold a single retry")
+	}
+}
+
+func TestProxyRewritesRequest(t *testing.T) {
+	var got *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+	}))
+	defer server.Close()
+
+	b := backend.New("api", server.URL+"/api", 1)
+	proxy := newTestProxy(t, 3, b)
+	if err := proxy.SetTrustedProxies([]string{"10.0.0.0/8"}); err != nil {
+		t.Fatalf("SetTrustedProxies failed: %v", err)
+	}
+
+	newRequest := func(remoteAddr string) *http.Request {
+		req := httptest.NewRequest("GET", "http://lb.example.com/users?id=1", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("Connection", "keep-alive, X-Hop")
+		req.Header.Set("X-Hop", "1")
+		req.Header.Set("Keep-Alive", "timeout=5")
+		req.Header.Set("X-Forwarded-For", "1.2.3.4")
+		req.Header.Set("X-End-To-End", "1")
+		return req
+	}
+
+	// Untrusted client: forwarded headers are replaced
+	proxy.ServeHTTP(httptest.NewRecorder(), newRequest("192.0.2.1:1234"))
+	if got == nil {
+		t.Fatal("Backend did not receive the request")
+	}
+	if got.URL.Path != "/api/users" || got.URL.RawQuery != "id=1" {
+		t.Errorf("Backend got %s?%s, want /api/users?id=1", got.URL.Path, got.URL.RawQuery)
+	}
+	for _, name := range []string{"X-Hop", "Keep-Alive"} {
+		if got.Header.Get(name) != "" {
+			t.Errorf("Expected hop-by-hop header %s to be removed", name)
+		}
+	}
+	if got.Header.Get("X-End-To-End") != "1" {
+		t.Error("Expected end-to-end header to be forwarded")
+	}
+	if xff := got.Header.Get("X-Forwarded-For"); xff != "192.0.2.1" {
+		t.Errorf("X-Forwarded-For = %q, want %q", xff, "192.0.2.1")
+	}
+	if proto := got.Header.Get("X-Forwarded-Proto"); proto != "http" {
+		t.Errorf("X-Forwarded-Proto = %q, want %q", proto, "http")
+	}
+	if host := got.Header.Get("X-Forwarded-Host"); host != "lb.example.com" {
+		t.Errorf("X-Forwarded-Host = %q, want %q", host, "lb.example.com")
+	}
+	if fwd := got.Header.Get("Forwarded"); fwd != `for=192.0.2.1;host="lb.example.com";proto=http` {
+		t.Errorf("Forwarded = %q", fwd)
+	}
+	if got.Host != "lb.example.com" {
+		t.Errorf("Host = %q, want client host preserved", got.Host)
+	}
+
+	// Trusted proxy: the client's chain is extended
+	proxy.ServeHTTP(httptest.NewRecorder(), newRequest("10.1.2.3:1234"))
+	if xff := got.Header.Get("X-Forwarded-For"); xff != "1.2.3.4, 10.1.2.3" {
+		t.Errorf("X-Forwarded-For = %q, want %q", xff, "1.2.3.4, 10.1.2.3")
+	}
+
+	// Backends can ask for their own Host header
+	b.SetRewriteHost(true)
+	proxy.ServeHTTP(httptest.NewRecorder(), newRequest("192.0.2.1:1234"))
+	if got.Host != b.URL().Host {
+		t.Errorf("Host = %q, want %q", got.Host, b.URL().Host)
+	}
+}
+
+func TestProxyUpgradeTunnel(t *testing.T) {
+	// Backend that switches to a line echo protocol
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "echo" {
+			http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		brw.Flush()
+		io.Copy(conn, brw)
+	}))
+	defer server.Close()
+
+	b := backend.New("ws", server.URL, 1)
+	proxy := newTestProxy(t, 3, b)
+	front := httptest.NewServer(proxy)
+	defer front.Close()
+
+	conn, err := net.Dial("tcp", front.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial proxy: %v", err)
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "GET /ws HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("Failed to read upgrade response: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Upgrade status = %v, want %v", resp.StatusCode, http.StatusSwitchingProtocols)
+	}
+	if resp.Header.Get("Upgrade") != "echo" {
+		t.Errorf("Upgrade header = %q, want %q", resp.Header.Get("Upgrade"), "echo")
+	}
+
+	// Bytes flow both ways through the tunnel
+	fmt.Fprintf(conn, "hello\n")
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("Failed to read echo: %v", err)
+	}
+	if line != "hello\n" {
+		t.Errorf("Echo = %q, want %q", line, "hello\n")
+	}
+
+	// The open tunnel counts as an active connection
+	if got := b.GetActiveConnections(); got != 1 {
+		t.Errorf("Expected 1 active connection during tunnel, got %d", got)
+	}
+
+	conn.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for b.GetActiveConnections() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := b.GetActiveConnections(); got != 0 {
+		t.Errorf("Expected 0 active connections after tunnel closed, got %d", got)
+	}
+}
+
+// newStreamTestProxy creates a proxy in front of handler, served over a real
+// connection so flushing and trailers can be observed
+func newStreamTestProxy(t *testing.T, handler http.HandlerFunc) (*Proxy, *httptest.Server) {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	proxy := newTestProxy(t, 3, backend.New("stream", server.URL, 1))
+	front := httptest.NewServer(proxy)
+	t.Cleanup(front.Close)
+	return proxy, front
+}
+
+func TestProxyStreamsEventStream(t *testing.T) {
+	for _, contentType := range []string{"text/event-stream", "application/grpc+proto"} {
+		t.Run(contentType, func(t *testing.T) {
+			release := make(chan struct{})
+			_, front := newStreamTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", contentType)
+				w.Write([]byte("data: first\n\n"))
+				http.NewResponseController(w).Flush()
+				<-release
+				w.Write([]byte("data: second\n\n"))
+			})
+			defer close(release)
+
+			// The first event must arrive while the backend is still streaming
+			lines := make(chan string, 1)
+			go func() {
+				resp, err := http.Get(front.URL + "/events")
+				if err != nil {
+					lines <- err.Error()
+					return
+				}
+				defer resp.Body.Close()
+				line, _ := bufio.NewReader(resp.Body).ReadString('\n')
+				lines <- line
+			}()
+			select {
+			case line := <-lines:
+				if line != "data: first\n" {
+					t.Errorf("First line = %q, want %q", line, "data: first\n")
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("Timeout waiting for first event; response was not flushed")
+			}
+		})
+	}
+}
+
+func TestProxyForwardsTrailers(t *testing.T) {
+	_, front := newStreamTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Checksum")
+		w.Write([]byte("body"))
+		w.Header().Set("X-Checksum", "abc123")
+	})
+
+	resp, err := http.Get(front.URL + "/")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	io.ReadAll(resp.Body)
+
+	if got := resp.Trailer.Get("X-Checksum"); got != "abc123" {
+		t.Errorf("Trailer X-Checksum = %q, want %q", got, "abc123")
+	}
+}
+
+func TestProxyRouteTimeouts(t *testing.T) {
+	proxy, front := newStreamTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow-headers" {
+			time.Sleep(500 * time.Millisecond)
+		}
+		w.Write([]byte("ok"))
+	})
+	proxy.SetRetryConfig(&retry.Config{Policy: retry.DefaultPolicy()})
+	proxy.SetRoutes([]Route{{PathPrefix: "/slow-headers", IdleTimeout: 50 * time.Millisecond}})
+
+	// A backend that stalls longer than the idle timeout gets a gateway timeout
+	resp, err := http.Get(front.URL + "/slow-headers")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("Status = %v, want %v", resp.StatusCode, http.StatusGatewayTimeout)
+	}
+
+	// Other routes keep the default settings
+	resp, err = http.Get(front.URL + "/fast")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Status = %v, want %v", resp.StatusCode, http.StatusOK)
+	}
+}
+
+// newH2CServer starts a server accepting cleartext HTTP/2 with prior knowledge
+func newH2CServer(t *testing.T, handler http.Handler) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewUnstartedServer(handler)
+	server.Config.Protocols = new(http.Protocols)
+	server.Config.Protocols.SetHTTP1(true)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Start()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestProxyH2C(t *testing.T) {
+	server := newH2CServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Backend-Proto", r.Proto)
+	}))
+
+	b := backend.New("grpc", server.URL, 1)
+	b.SetProtocol(backend.ProtocolH2C)
+	proxy := newTestProxy(t, 3, b)
+	front := newH2CServer(t, proxy)
+
+	transport := &http.Transport{Protocols: new(http.Protocols)}
+	transport.Protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: transport}
+	resp, err := client.Get(front.URL + "/")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.ProtoMajor != 2 {
+		t.Errorf("Client protocol = %s, want HTTP/2.0", resp.Proto)
+	}
+	if got := resp.Header.Get("X-Backend-Proto"); got != "HTTP/2.0" {
+		t.Errorf("Backend protocol = %q, want %q", got, "HTTP/2.0")
+	}
+}
+
+func TestProxyGRPCStatus(t *testing.T) {
+	server := newH2CServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Write([]byte{0, 0, 0, 0, 0})
+		w.Header().Set("Grpc-Status", r.Header.Get("X-Status"))
+	}))
+
+	b := backend.New("grpc", server.URL, 1)
+	b.SetProtocol(backend.ProtocolH2C)
+	proxy := newTestProxy(t, 3, b)
+	front := httptest.NewServer(proxy)
+	defer front.Close()
+
+	tests := []struct {
+		code     string
+		failures int
+	}{
+		{"0", 0},  // OK
+		{"5", 0},  // NOT_FOUND is an application error
+		{"14", 1}, // UNAVAILABLE
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodPost, front.URL+"/pkg.Service/Method", strings.NewReader(""))
+		req.Header.Set("Content-Type", "application/grpc")
+		req.Header.Set("X-Status", tt.code)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if got := resp.Trailer.Get("Grpc-Status"); got != tt.code {
+			t.Errorf("Trailer Grpc-Status = %q, want %q", got, tt.code)
+		}
+		if got := b.GetCircuitBreaker().GetFailureCount(); got != tt.failures {
+			t.Errorf("grpc-status %s: failure count = %d, want %d", tt.code, got, tt.failures)
+		}
+	}
+}
+
+func TestProxyGRPCRetryableStatus(t *testing.T) {
+	server := newH2CServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/grpc")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+
+	b := backend.New("grpc", server.URL, 1)
+	b.SetProtocol(backend.ProtocolH2C)
+	proxy := newTestProxy(t, 1, b)
+	// gRPC calls are POSTs, so retries must be allowed for the route
+	proxy.SetRoutes([]Route{{PathPrefix: "/pkg.Service/", RetryNonIdempotent: true}})
+	detector := outlier.New(outlier.Config{
+		Consecutive5xx:     1,
+		BaseEjectionTime:   time.Minute,
+		MaxEjectionPercent: 100,
+	})
+	detector.AddBackend(b)
+	proxy.SetOutlierDetector(detector)
+
+	// The 503 is kept for a retry, but still counts against the backend
+	req := httptest.NewRequest(http.MethodPost, "/pkg.Service/Method", strings.NewReader(""))
+	req.Header.Set("Content-Type", "application/grpc")
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("ServeHTTP() status = %v, want %v", w.Code, http.StatusServiceUnavailable)
+	}
+	if got := b.GetCircuitBreaker().GetFailureCount(); got != 1 {
+		t.Errorf("Failure count = %d, want 1", got)
+	}
+	if !b.IsEjected() {
+		t.Error("Expected backend to be ejected after a 503")
+	}
+}
+
+func TestProxyEjectsOutliers(t *testing.T) {
+	proxy, failedHits := newRetryTestProxy(t)
+	proxy.SetRetryConfig(&retry.Config{Policy: retry.DefaultPolicy()})
+
+	detector := outlier.New(outlier.Config{
+		Consecutive5xx:     1,
+		BaseEjectionTime:   time.Minute,
+		MaxEjectionPercent: 50,
+	})
+	for _, id := range []string{"failing", "echo"} {
+		b, err := proxy.balancer.GetBackend(id)
+		if err != nil {
+			t.Fatalf("Failed to get backend: %v", err)
+		}
+		detector.AddBackend(b)
+	}
+	proxy.SetOutlierDetector(detector)
+
+	// Once the failing backend has returned an error it is out of rotation
+	for i := 0; i < 4; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		proxy.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if *failedHits != 1 {
+		t.Errorf("Failing backend hit %d times, want 1", *failedHits)
+	}
+}
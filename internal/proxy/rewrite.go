@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"load-balancer/internal/backend"
+)
+
+// hopHeaders are hop-by-hop headers that apply to a single connection and must
+// not be forwarded (RFC 7230, section 6.1)
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// SetTrustedProxies sets the networks whose X-Forwarded-* and Forwarded headers
+// are trusted. Forwarded headers from any other client are replaced.
+func (p *Proxy) SetTrustedProxies(cidrs []string) error {
+	trusted := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy %q: %v", cidr, err)
+		}
+		trusted = append(trusted, network)
+	}
+	p.trustedProxies = trusted
+	return nil
+}
+
+// isTrusted reports whether the client's forwarded headers can be trusted
+func (p *Proxy) isTrusted(clientIP string) bool {
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return false
+	}
+	for _, network := range p.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// targetURL joins the backend URL with the request path and query
+func targetURL(base *url.URL, r *http.Request) *url.URL {
+	target := *base
+	target.Path = joinPath(base.Path, r.URL.Path)
+	if base.RawPath != "" || r.URL.RawPath != "" {
+		target.RawPath = joinPath(base.EscapedPath(), r.URL.EscapedPath())
+	}
+
+	switch {
+	case base.RawQuery == "":
+		target.RawQuery = r.URL.RawQuery
+	case r.URL.RawQuery != "":
+		target.RawQuery = base.RawQuery + "&" + r.URL.RawQuery
+	}
+	return &target
+}
+
+// joinPath joins two URL paths with exactly one slash between them
+func joinPath(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash && b != "":
+		return a + "/" + b
+	}
+	return a + b
+}
+
+// rewriteRequest copies the client request headers onto the backend request,
+// dropping hop-by-hop headers and setting the forwarded headers
+func (p *Proxy) rewriteRequest(req *http.Request, r *http.Request, b *backend.Backend) {
+	req.Header = r.Header.Clone()
+	if req.Header == nil {
+		req.Header = make(http.Header)
+	}
+	removeHopHeaders(req.Header)
+
+	// Keep "TE: trailers", which gRPC relies on
+	for _, te := range r.Header.Values("Te") {
+		if strings.EqualFold(strings.TrimSpace(te), "trailers") {
+			req.Header.Set("Te", "trailers")
+		}
+	}
+
+	// Preserve the client's Host unless the backend needs its own
+	if b.RewriteHost() {
+		req.Host = b.URL().Host
+	} else {
+		req.Host = r.Host
+	}
+
+	clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		clientIP = r.RemoteAddr
+	}
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+
+	// Untrusted clients cannot pass forwarded headers through
+	if !p.isTrusted(clientIP) {
+		req.Header.Del("X-Forwarded-For")
+		req.Header.Del("X-Forwarded-Proto")
+		req.Header.Del("X-Forwarded-Host")
+		req.Header.Del("Forwarded")
+	}
+
+	if prior := req.Header.Values("X-Forwarded-For"); len(prior) > 0 {
+		req.Header.Set("X-Forwarded-For", strings.Join(prior, ", ")+", "+clientIP)
+	} else {
+		req.Header.Set("X-Forwarded-For", clientIP)
+	}
+	if req.Header.Get("X-Forwarded-Proto") == "" {
+		req.Header.Set("X-Forwarded-Proto", proto)
+	}
+	if req.Header.Get("X-Forwarded-Host") == "" {
+		req.Header.Set("X-Forwarded-Host", r.Host)
+	}
+
+	// RFC 7239 Forwarded element for this hop
+	forwarded := fmt.Sprintf("for=%s;host=%q;proto=%s", forwardedNode(clientIP), r.Host, proto)
+	if prior := req.Header.Values("Forwarded"); len(prior) > 0 {
+		forwarded = strings.Join(prior, ", ") + ", " + forwarded
+	}
+	req.Header.Set("Forwarded", forwarded)
+}
+
+// forwardedNode formats an IP address as a Forwarded header node
+func forwardedNode(ip string) string {
+	if strings.Contains(ip, ":") {
+		return fmt.Sprintf("\"[%s]\"", ip)
+	}
+	return ip
+}
+
+// removeHopHeaders removes hop-by-hop headers, including any listed in Connection
+func removeHopHeaders(h http.Header) {
+	for _, value := range h.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Route holds proxy settings for requests whose path starts with PathPrefix
+type Route struct {
+	PathPrefix string
+	// RetryNonIdempotent allows retrying methods such as POST and PATCH
+	RetryNonIdempotent bool
+	// FlushInterval is how often streamed responses are flushed to the client.
+	// Zero leaves buffering to the server, negative flushes after every write.
+	FlushInterval time.Duration
+	// Timeout bounds a whole attempt, including streaming the response body
+	Timeout time.Duration
+	// IdleTimeout bounds the time without receiving data from the backend
+	IdleTimeout time.Duration
+}
+
+// SetRoutes sets the per-path route settings. The route with the longest
+// matching prefix applies to a request.
+func (p *Proxy) SetRoutes(routes []Route) {
+	p.routes = routes
+}
+
+// SetDefaultRoute sets the settings for requests no route matches. Its
+// durations also apply to routes that leave them unset.
+func (p *Proxy) SetDefaultRoute(route Route) {
+	p.defaultRoute = route
+}
+
+// route returns the settings for a request, falling back to the default route
+func (p *Proxy) route(r *http.Request) Route {
+	matched := p.defaultRoute
+	found := false
+	for _, route := range p.routes {
+		if strings.HasPrefix(r.URL.Path, route.PathPrefix) && (!found || len(route.PathPrefix) >= len(matched.PathPrefix)) {
+			matched = route
+			found = true
+		}
+	}
+
+	if matched.FlushInterval == 0 {
+		matched.FlushInterval = p.defaultRoute.FlushInterval
+	}
+	if matched.Timeout == 0 {
+		matched.Timeout = p.defaultRoute.Timeout
+	}
+	if matched.IdleTimeout == 0 {
+		matched.IdleTimeout = p.defaultRoute.IdleTimeout
+	}
+	return matched
+}
+
+// isIdempotent reports whether a request method may be safely retried
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
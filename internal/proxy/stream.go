@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// flushIntervalFor returns how often a response should be flushed to the
+// client. A negative interval flushes after every write.
+func flushIntervalFor(resp *http.Response, route Route) time.Duration {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "text/event-stream" || strings.HasPrefix(mediaType, "application/grpc") {
+		// Streaming protocols must see every message as soon as it arrives
+		return -1
+	}
+	return route.FlushInterval
+}
+
+// latencyWriter flushes writes to the client at most one interval after they
+// were made
+type latencyWriter struct {
+	mu       sync.Mutex
+	w        io.Writer
+	rc       *http.ResponseController
+	interval time.Duration
+	timer    *time.Timer
+	pending  bool
+	stopped  bool
+}
+
+// Write writes p and schedules or performs a flush
+func (lw *latencyWriter) Write(p []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	n, err := lw.w.Write(p)
+	if lw.interval < 0 {
+		lw.rc.Flush()
+		return n, err
+	}
+	if !lw.pending {
+		lw.pending = true
+		if lw.timer == nil {
+			lw.timer = time.AfterFunc(lw.interval, lw.delayedFlush)
+		} else {
+			lw.timer.Reset(lw.interval)
+		}
+	}
+	return n, err
+}
+
+// delayedFlush flushes pending writes once the interval has passed
+func (lw *latencyWriter) delayedFlush() {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	if !lw.pending || lw.stopped {
+		return
+	}
+	lw.rc.Flush()
+	lw.pending = false
+}
+
+// stop cancels any scheduled flush
+func (lw *latencyWriter) stop() {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+
+	lw.stopped = true
+	if lw.timer != nil {
+		lw.timer.Stop()
+	}
+}
+
+// idleReader calls reset on every read so that an idle timer can detect
+// stalled backends
+type idleReader struct {
+	r     io.Reader
+	reset func()
+}
+
+// Read reads from the underlying reader and resets the idle timer
+func (ir *idleReader) Read(p []byte) (int, error) {
+	n, err := ir.r.Read(p)
+	ir.reset()
+	return n, err
+}
+
+// copyResponse copies the response body to the client, flushing according to
+// the flush interval. With a zero interval the server's own buffering applies.
+func copyResponse(w http.ResponseWriter, body io.Reader, flushInterval time.Duration) error {
+	var dst io.Writer = w
+	if flushInterval != 0 {
+		lw := &latencyWriter{
+			w:        w,
+			rc:       http.NewResponseController(w),
+			interval: flushInterval,
+		}
+		defer lw.stop()
+		dst = lw
+	}
+
+	_, err := io.Copy(dst, body)
+	return err
+}
+
+// announceTrailers declares the response trailers before the header is written
+func announceTrailers(w http.ResponseWriter, resp *http.Response) {
+	for name := range resp.Trailer {
+		w.Header().Add("Trailer", name)
+	}
+	// Trailers need a chunked body on HTTP/1.1
+	if len(resp.Trailer) > 0 {
+		w.Header().Del("Content-Length")
+	}
+}
+
+// copyTrailers sets the trailers received after the response body
+func copyTrailers(w http.ResponseWriter, resp *http.Response) {
+	for name, values := range resp.Trailer {
+		w.Header()[http.TrailerPrefix+name] = values
+	}
+}
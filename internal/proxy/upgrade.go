@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"load-balancer/internal/backend"
+)
+
+// upgradeType returns the protocol a request asks to upgrade to, or "" if it is
+// not an upgrade request
+func upgradeType(h http.Header) string {
+	for _, value := range h.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return h.Get("Upgrade")
+			}
+		}
+	}
+	return ""
+}
+
+// handleUpgrade completes a protocol switch: it hijacks the client connection,
+// relays the backend's 101 response and splices bytes in both directions until
+// either side closes. The caller's connection accounting stays in place for the
+// lifetime of the tunnel.
+func (p *Proxy) handleUpgrade(w http.ResponseWriter, r *http.Request, resp *http.Response, b *backend.Backend) error {
+	reqType := upgradeType(r.Header)
+	respType := upgradeType(resp.Header)
+	if !strings.EqualFold(reqType, respType) {
+		resp.Body.Close()
+		return fmt.Errorf("%w: %s switched to protocol %q, requested %q", ErrBackendError, b.ID(), respType, reqType)
+	}
+
+	backConn, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		resp.Body.Close()
+		return fmt.Errorf("%w: %s returned a non-writable upgrade body", ErrBackendError, b.ID())
+	}
+	defer backConn.Close()
+
+	clientConn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return fmt.Errorf("failed to hijack client connection: %v", err)
+	}
+	defer clientConn.Close()
+
+	// Close the backend side when the request is cancelled
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-r.Context().Done():
+			backConn.Close()
+		case <-done:
+		}
+	}()
+
+	// Relay the backend's 101 response with its hop-by-hop upgrade headers
+	removeHopHeaders(resp.Header)
+	resp.Header.Set("Connection", "Upgrade")
+	resp.Header.Set("Upgrade", respType)
+	resp.Body = nil
+	if err := resp.Write(brw); err != nil {
+		log.Printf("Failed to write upgrade response for backend %s: %v", b.ID(), err)
+		return nil
+	}
+	if err := brw.Flush(); err != nil {
+		log.Printf("Failed to flush upgrade response for backend %s: %v", b.ID(), err)
+		return nil
+	}
+
+	// Splice until either direction finishes. Reading from brw first drains
+	// anything the server already buffered from the client.
+	errc := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(backConn, brw)
+		errc <- err
+	}()
+	go func() {
+		_, err := io.Copy(clientConn, backConn)
+		errc <- err
+	}()
+	<-errc
+
+	return nil
+}
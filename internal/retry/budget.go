@@ -0,0 +1,107 @@
+package retry
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrBudgetExhausted is returned when a retry is denied by the retry budget
+var ErrBudgetExhausted = errors.New("retry budget exhausted")
+
+// budgetBuckets is the number of buckets the budget window is divided into
+const budgetBuckets = 10
+
+// BudgetConfig holds the retry budget configuration
+type BudgetConfig struct {
+	// Ratio is the fraction of recent requests that may be retried
+	Ratio float64
+	// MinRetriesPerSecond allows a minimum rate of retries regardless of traffic
+	MinRetriesPerSecond int
+	// TTL is the window over which requests and retries are counted
+	TTL time.Duration
+}
+
+// DefaultBudgetConfig returns a default retry budget configuration
+func DefaultBudgetConfig() BudgetConfig {
+	return BudgetConfig{
+		Ratio:               0.2,
+		MinRetriesPerSecond: 10,
+		TTL:                 10 * time.Second,
+	}
+}
+
+// budgetBucket counts requests and retries during one slice of the window
+type budgetBucket struct {
+	epoch       int64
+	deposits    int
+	withdrawals int
+}
+
+// Budget limits retries to a fraction of recent requests plus a minimum rate.
+// Every request deposits Ratio tokens and every retry withdraws one; tokens
+// expire after TTL. A single Budget is shared by all requests to a backend pool
+// so that an outage cannot multiply backend load by the retry count.
+type Budget struct {
+	config  BudgetConfig
+	width   time.Duration
+	buckets [budgetBuckets]budgetBucket
+	mu      sync.Mutex
+	now     func() time.Time
+}
+
+// NewBudget creates a new retry budget
+func NewBudget(config BudgetConfig) *Budget {
+	if config.TTL <= 0 {
+		config.TTL = 10 * time.Second
+	}
+
+	return &Budget{
+		config: config,
+		width:  max(config.TTL/budgetBuckets, 1),
+		now:    time.Now,
+	}
+}
+
+// Deposit records a request, allowing Ratio more retries
+func (b *Budget) Deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.current().deposits++
+}
+
+// Withdraw attempts to take a token for a retry. It returns false if the
+// budget is exhausted and the retry must not be made.
+func (b *Budget) Withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	cur := b.current()
+	deposits, withdrawals := 0, 0
+	for _, bucket := range b.buckets {
+		if cur.epoch-bucket.epoch < budgetBuckets {
+			deposits += bucket.deposits
+			withdrawals += bucket.withdrawals
+		}
+	}
+
+	balance := float64(b.config.MinRetriesPerSecond)*b.config.TTL.Seconds() +
+		b.config.Ratio*float64(deposits) - float64(withdrawals)
+	if balance < 1 {
+		return false
+	}
+
+	cur.withdrawals++
+	return true
+}
+
+// current returns the bucket for the current time, resetting it if it last
+// held counts from an expired slice of the window
+func (b *Budget) current() *budgetBucket {
+	epoch := b.now().UnixNano() / int64(b.width)
+	bucket := &b.buckets[epoch%budgetBuckets]
+	if bucket.epoch != epoch {
+		*bucket = budgetBucket{epoch: epoch}
+	}
+	return bucket
+}
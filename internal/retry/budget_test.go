@@ -0,0 +1,82 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBudget(t *testing.T) {
+	now := time.Unix(1000, 0)
+	budget := NewBudget(BudgetConfig{Ratio: 0.2, TTL: 10 * time.Second})
+	budget.now = func() time.Time { return now }
+
+	for range make([]struct{}, 100) {
+		budget.Deposit()
+	}
+
+	// 20% of 100 requests may be retried
+	for i := 0; i < 20; i++ {
+		if !budget.Withdraw() {
+			t.Fatalf("Expected retry %d to be allowed", i+1)
+		}
+	}
+	if budget.Withdraw() {
+		t.Error("Expected retry to be denied once the budget is spent")
+	}
+
+	// Deposits expire after the TTL
+	now = now.Add(11 * time.Second)
+	budget.Deposit()
+	if budget.Withdraw() {
+		t.Error("Expected retry to be denied after deposits expired")
+	}
+}
+
+func TestBudgetMinRetries(t *testing.T) {
+	now := time.Unix(1000, 0)
+	budget := NewBudget(BudgetConfig{MinRetriesPerSecond: 1, TTL: 10 * time.Second})
+	budget.now = func() time.Time { return now }
+
+	// Without any traffic the minimum rate still allows retries
+	for i := 0; i < 10; i++ {
+		if !budget.Withdraw() {
+			t.Fatalf("Expected retry %d to be allowed by the minimum rate", i+1)
+		}
+	}
+	if budget.Withdraw() {
+		t.Error("Expected retry to be denied above the minimum rate")
+	}
+}
+
+func TestBudgetShortTTL(t *testing.T) {
+	// A TTL shorter than the bucket count in nanoseconds still works
+	budget := NewBudget(BudgetConfig{Ratio: 1, TTL: time.Nanosecond})
+	budget.Deposit()
+	budget.Withdraw()
+}
+
+func TestDoBudgetExhausted(t *testing.T) {
+	config := &Config{
+		MaxRetries:      3,
+		InitialInterval: time.Millisecond,
+		MaxInterval:     time.Millisecond,
+		Multiplier:      1,
+		Budget:          NewBudget(BudgetConfig{TTL: time.Second}),
+	}
+
+	calls := 0
+	failure := errors.New("backend down")
+	err := Do(context.Background(), config, func() error {
+		calls++
+		return NewRetryableError(failure)
+	})
+
+	if calls != 1 {
+		t.Errorf("Expected a single call with an empty budget, got %d", calls)
+	}
+	if !errors.Is(err, ErrBudgetExhausted) || !errors.Is(err, failure) {
+		t.Errorf("Expected budget error wrapping the last failure, got %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package retry
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Policy decides which failed attempts are retried
+type Policy struct {
+	// RetryOnConnectError retries when no connection to the backend could be established
+	RetryOnConnectError bool
+	// RetryOnReset retries when the connection was reset before a response was received
+	RetryOnReset bool
+	// StatusCodes lists the response status codes that are retried
+	StatusCodes []int
+	// RespectRetryAfter delays the next attempt by the response's Retry-After header
+	RespectRetryAfter bool
+}
+
+const (
+	// DefaultPolicyName retries connect errors, resets and gateway errors
+	DefaultPolicyName = "default"
+	// ConnectFailurePolicyName only retries connect errors
+	ConnectFailurePolicyName = "connect-failure"
+	// NoRetryPolicyName never retries
+	NoRetryPolicyName = "none"
+)
+
+// DefaultPolicy returns the default retry policy
+func DefaultPolicy() Policy {
+	return Policy{
+		RetryOnConnectError: true,
+		RetryOnReset:        true,
+		StatusCodes:         []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout},
+		RespectRetryAfter:   true,
+	}
+}
+
+// PolicyByName returns a named retry policy
+func PolicyByName(name string) (Policy, error) {
+	switch name {
+	case DefaultPolicyName, "":
+		return DefaultPolicy(), nil
+	case ConnectFailurePolicyName:
+		return Policy{RetryOnConnectError: true}, nil
+	case NoRetryPolicyName:
+		return Policy{}, nil
+	default:
+		return Policy{}, fmt.Errorf("unknown retry policy: %s", name)
+	}
+}
+
+// ShouldRetryError reports whether a transport error is retryable. Timeouts are
+// never retried since the backend may already be processing the request.
+func (p Policy) ShouldRetryError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return p.RetryOnConnectError
+	}
+
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return p.RetryOnReset
+	}
+
+	return false
+}
+
+// ShouldRetryResponse reports whether a response is retryable and how long to
+// wait before the next attempt, if the backend asked for a delay
+func (p Policy) ShouldRetryResponse(resp *http.Response) (bool, time.Duration) {
+	if !slices.Contains(p.StatusCodes, resp.StatusCode) {
+		return false, 0
+	}
+	if !p.RespectRetryAfter {
+		return true, 0
+	}
+	return true, parseRetryAfter(resp.Header.Get("Retry-After"))
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if d := time.Until(date); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -0,0 +1,66 @@
+package retry
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestPolicyShouldRetryError(t *testing.T) {
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	readErr := &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+
+	tests := []struct {
+		name   string
+		policy Policy
+		err    error
+		want   bool
+	}{
+		{"connect error", DefaultPolicy(), fmt.Errorf("wrapped: %w", dialErr), true},
+		{"reset before response", DefaultPolicy(), readErr, true},
+		{"eof before response", DefaultPolicy(), io.EOF, true},
+		{"timeout", DefaultPolicy(), errors.New("context deadline exceeded"), false},
+		{"connect only ignores reset", Policy{RetryOnConnectError: true}, readErr, false},
+		{"no retry policy", Policy{}, dialErr, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.ShouldRetryError(tt.err); got != tt.want {
+				t.Errorf("ShouldRetryError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyShouldRetryResponse(t *testing.T) {
+	policy := DefaultPolicy()
+
+	resp := &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{}}
+	resp.Header.Set("Retry-After", "2")
+	retryable, after := policy.ShouldRetryResponse(resp)
+	if !retryable || after != 2*time.Second {
+		t.Errorf("ShouldRetryResponse(503) = %v, %v, want true, 2s", retryable, after)
+	}
+
+	resp = &http.Response{StatusCode: http.StatusInternalServerError, Header: http.Header{}}
+	if retryable, _ := policy.ShouldRetryResponse(resp); retryable {
+		t.Error("Expected 500 not to be retried by the default policy")
+	}
+}
+
+func TestPolicyByName(t *testing.T) {
+	for _, name := range []string{DefaultPolicyName, ConnectFailurePolicyName, NoRetryPolicyName} {
+		if _, err := PolicyByName(name); err != nil {
+			t.Errorf("PolicyByName(%q) failed: %v", name, err)
+		}
+	}
+	if _, err := PolicyByName("bogus"); err == nil {
+		t.Error("Expected error for unknown policy")
+	}
+}
@@ -3,6 +3,7 @@ package retry
 import (
 	"context"
 	"errors"
+	"fmt"
 	"math"
 	"math/rand"
 	"time"
@@ -15,17 +16,31 @@ type Config struct {
 	MaxInterval     time.Duration
 	Multiplier      float64
 	Randomization   float64
+	// MaxElapsedTime bounds the total time spent retrying a single call.
+	// Zero means no limit.
+	MaxElapsedTime time.Duration
+	// Policy decides which failures are retryable
+	Policy Policy
+	// Budget limits retries across all calls sharing it. Nil means no limit.
+	Budget *Budget
 }
 
 // RetryableError represents an error that can be retried
 type RetryableError struct {
 	Err error
+	// After is the minimum delay before the next attempt, e.g. from Retry-After
+	After time.Duration
 }
 
 func (e *RetryableError) Error() string {
 	return e.Err.Error()
 }
 
+// Unwrap returns the underlying error
+func (e *RetryableError) Unwrap() error {
+	return e.Err
+}
+
 // NewRetryableError creates a new retryable error
 func NewRetryableError(err error) error {
 	return &RetryableError{Err: err}
@@ -39,6 +54,7 @@ func DefaultConfig() Config {
 		MaxInterval:     1 * time.Second,
 		Multiplier:      2.0,
 		Randomization:   0.1,
+		Policy:          DefaultPolicy(),
 	}
 }
 
@@ -46,6 +62,11 @@ func DefaultConfig() Config {
 func Do(ctx context.Context, config *Config, fn func() error) error {
 	var err error
 	interval := config.InitialInterval
+	start := time.Now()
+
+	if config.Budget != nil {
+		config.Budget.Deposit()
+	}
 
 	for i := 0; i <= config.MaxRetries; i++ {
 		// Execute the function
@@ -60,6 +81,11 @@ func Do(ctx context.Context, config *Config, fn func() error) error {
 			return err
 		}
 
+		// No retries left
+		if i == config.MaxRetries {
+			break
+		}
+
 		// Check if context is done
 		select {
 		case <-ctx.Done():
@@ -77,12 +103,28 @@ func Do(ctx context.Context, config *Config, fn func() error) error {
 		jitter := float64(interval) * config.Randomization
 		interval = interval + time.Duration(rand.Float64()*jitter)
 
+		// Honor a delay requested by the failed attempt
+		wait := interval
+		if retryableErr.After > wait {
+			wait = retryableErr.After
+		}
+
+		// Give up if the next attempt would exceed the total retry budget
+		if config.MaxElapsedTime > 0 && time.Since(start)+wait > config.MaxElapsedTime {
+			break
+		}
+
 		// Wait for the next retry
 		select {
-		case <-time.After(interval):
+		case <-time.After(wait):
 		case <-ctx.Done():
 			return ctx.Err()
 		}
+
+		// Check the shared retry budget right before retrying
+		if config.Budget != nil && !config.Budget.Withdraw() {
+			return fmt.Errorf("%w: %w", ErrBudgetExhausted, err)
+		}
 	}
 
 	return err
@@ -86,6 +86,11 @@ func (m *Manager) GetBackendID(r *http.Request) string {
 		return ""
 	}
 
+	return m.GetBackendIDForKey(sessionKey)
+}
+
+// GetBackendIDForKey returns the backend ID of an unexpired session key
+func (m *Manager) GetBackendIDForKey(sessionKey string) string {
 	if sessionKey == "" {
 		return ""
 	}
@@ -127,7 +132,12 @@ func (m *Manager) SetBackendID(r *http.Request, w http.ResponseWriter, backendID
 		}
 	}
 
-	if sessionKey == "" {
+	m.SetBackendIDForKey(sessionKey, backendID)
+}
+
+// SetBackendIDForKey binds a session key to a backend for the session TTL
+func (m *Manager) SetBackendIDForKey(sessionKey string, backendID string) {
+	if sessionKey == "" || backendID == "" {
 		return
 	}
 
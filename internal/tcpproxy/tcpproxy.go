@@ -0,0 +1,319 @@
+package tcpproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"load-balancer/internal/backend"
+	"load-balancer/internal/balancer"
+	"load-balancer/internal/metrics"
+)
+
+const (
+	// DefaultDialTimeout is the default time allowed to connect to a backend
+	DefaultDialTimeout = 5 * time.Second
+	// DefaultIdleTimeout is the default time a connection may carry no data
+	DefaultIdleTimeout = 5 * time.Minute
+)
+
+// ErrServerClosed is returned by Serve after the proxy has been shut down
+var ErrServerClosed = errors.New("tcpproxy: server closed")
+
+// Config holds the TCP proxy configuration
+type Config struct {
+	// DialTimeout bounds connecting to a backend
+	DialTimeout time.Duration
+	// IdleTimeout closes connections without data in either direction
+	IdleTimeout time.Duration
+}
+
+// DefaultConfig returns the default TCP proxy configuration
+func DefaultConfig() Config {
+	return Config{
+		DialTimeout: DefaultDialTimeout,
+		IdleTimeout: DefaultIdleTimeout,
+	}
+}
+
+// Proxy is a layer-4 load balancer that splices client connections to
+// backends chosen by a balancer. Backend URLs are used for their host and
+// port only, e.g. tcp://10.0.0.1:5432.
+type Proxy struct {
+	balancer balancer.Balancer
+	metrics  *metrics.Metrics
+	config   Config
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// New creates a new TCP proxy
+func New(m *metrics.Metrics, config Config) *Proxy {
+	return &Proxy{
+		metrics:   m,
+		config:    config,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// SetBalancer sets the load balancer
+func (p *Proxy) SetBalancer(b balancer.Balancer) {
+	p.balancer = b
+}
+
+// ListenAndServe listens on the TCP address and proxies accepted connections
+func (p *Proxy) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return p.Serve(l)
+}
+
+// Serve accepts connections on the listener until the proxy is shut down,
+// then returns ErrServerClosed
+func (p *Proxy) Serve(l net.Listener) error {
+	if !p.trackListener(l, true) {
+		l.Close()
+		return ErrServerClosed
+	}
+	defer p.trackListener(l, false)
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if p.isClosed() {
+				return ErrServerClosed
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(5 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		if !p.trackConn(conn, true) {
+			conn.Close()
+			continue
+		}
+		go p.handle(conn)
+	}
+}
+
+// Shutdown stops accepting connections and waits for active connections to
+// finish. Connections still open when the context is done are closed.
+func (p *Proxy) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	p.closed = true
+	for l := range p.listeners {
+		l.Close()
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		p.Close()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting connections and closes all active connections
+func (p *Proxy) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for l := range p.listeners {
+		l.Close()
+	}
+	for conn := range p.conns {
+		conn.Close()
+	}
+	return nil
+}
+
+func (p *Proxy) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
+
+func (p *Proxy) trackListener(l net.Listener, add bool) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if add {
+		if p.closed {
+			return false
+		}
+		p.listeners[l] = struct{}{}
+	} else {
+		delete(p.listeners, l)
+	}
+	return true
+}
+
+func (p *Proxy) trackConn(conn net.Conn, add bool) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if add {
+		if p.closed {
+			return false
+		}
+		p.conns[conn] = struct{}{}
+		p.wg.Add(1)
+	} else {
+		delete(p.conns, conn)
+		p.wg.Done()
+	}
+	return true
+}
+
+// handle proxies a single client connection
+func (p *Proxy) handle(client net.Conn) {
+	defer p.trackConn(client, false)
+	defer client.Close()
+
+	p.metrics.IncrementTotalRequests()
+
+	b, server, err := p.dial(client)
+	if err != nil {
+		p.metrics.IncrementFailedRequests()
+		log.Printf("Failed to proxy connection from %s: %v", client.RemoteAddr(), err)
+		return
+	}
+	defer server.Close()
+
+	// Count the connection against the backend for its lifetime
+	b.IncrementConnections()
+	defer b.DecrementConnections()
+	p.metrics.IncrementActiveConnections(b.ID())
+	defer p.metrics.DecrementActiveConnections(b.ID())
+
+	p.splice(client, server)
+}
+
+// dial connects to a backend chosen by the balancer. A backend that refuses
+// the connection is recorded in its circuit breaker and the next one is tried.
+func (p *Proxy) dial(client net.Conn) (*backend.Backend, net.Conn, error) {
+	routing := &balancer.Request{ClientAddr: client.RemoteAddr().String()}
+	var lastErr error
+	for {
+		b, err := p.balancer.Next(routing)
+		if err != nil {
+			// Report the last backend failure rather than running out of backends
+			if lastErr != nil {
+				return nil, nil, lastErr
+			}
+			return nil, nil, err
+		}
+		routing.Exclude(b.ID())
+		permit, ok := b.GetCircuitBreaker().Acquire()
+		if !ok {
+			continue
+		}
+		p.metrics.IncrementBackendRequests(b.ID())
+
+		start := time.Now()
+		conn, err := net.DialTimeout("tcp", b.URL().Host, p.config.DialTimeout)
+		if err != nil {
+			permit.Record(false, time.Since(start))
+			p.metrics.IncrementBackendFailures(b.ID())
+			lastErr = fmt.Errorf("backend %s: %w", b.ID(), err)
+			continue
+		}
+		permit.Record(true, time.Since(start))
+		p.observeLatency(b, time.Since(start))
+		return b, conn, nil
+	}
+}
+
+// observeLatency records the time a backend took to accept a connection and
+// feeds it to latency-aware balancers
+func (p *Proxy) observeLatency(b *backend.Backend, latency time.Duration) {
+	p.metrics.RecordBackendLatency(b.ID(), latency)
+	if o, ok := p.balancer.(balancer.Observer); ok {
+		o.Observe(b.ID(), latency)
+	}
+}
+
+// splice copies bytes in both directions. When one side finishes sending, its
+// peer is half-closed so the other direction can drain; an error or idle
+// timeout closes both connections.
+func (p *Proxy) splice(client, server net.Conn) {
+	var lastActivity atomic.Int64
+	lastActivity.Store(time.Now().UnixNano())
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	relay := func(dst, src net.Conn) {
+		defer wg.Done()
+		if err := p.copy(dst, src, &lastActivity); err != nil {
+			client.Close()
+			server.Close()
+			return
+		}
+		closeWrite(dst)
+	}
+	go relay(server, client)
+	go relay(client, server)
+	wg.Wait()
+}
+
+// copy relays src to dst until src reaches EOF. Reads time out after the idle
+// timeout unless the other direction has seen data in the meantime.
+func (p *Proxy) copy(dst, src net.Conn, lastActivity *atomic.Int64) error {
+	buf := make([]byte, 32*1024)
+	for {
+		if p.config.IdleTimeout > 0 {
+			src.SetReadDeadline(time.Now().Add(p.config.IdleTimeout))
+		}
+		n, err := src.Read(buf)
+		if n > 0 {
+			lastActivity.Store(time.Now().UnixNano())
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() && p.config.IdleTimeout > 0 {
+				idle := time.Since(time.Unix(0, lastActivity.Load()))
+				if idle < p.config.IdleTimeout {
+					continue
+				}
+			}
+			return err
+		}
+	}
+}
+
+// closeWrite signals EOF to the peer while keeping the read side open
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+		return
+	}
+	conn.Close()
+}
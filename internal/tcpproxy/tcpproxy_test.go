@@ -0,0 +1,217 @@
+package tcpproxy
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"load-balancer/internal/backend"
+	"load-balancer/internal/balancer"
+	"load-balancer/internal/metrics"
+)
+
+// newEchoBackend starts a TCP server that echoes lines prefixed with its ID
+func newEchoBackend(t *testing.T, id string) *backend.Backend {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					conn.Write([]byte(id + ":" + line))
+				}
+			}()
+		}
+	}()
+
+	return backend.New(id, "tcp://"+l.Addr().String(), 1)
+}
+
+// startProxy serves a TCP proxy over the backends on a local port
+func startProxy(t *testing.T, config Config, backends ...*backend.Backend) (*Proxy, string) {
+	t.Helper()
+
+	bal, err := balancer.New("round-robin")
+	if err != nil {
+		t.Fatalf("Failed to create balancer: %v", err)
+	}
+	for _, b := range backends {
+		bal.AddBackend(b.ID(), b)
+	}
+
+	p := New(metrics.New(), config)
+	p.SetBalancer(bal)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go p.Serve(l)
+	t.Cleanup(func() { p.Close() })
+	return p, l.Addr().String()
+}
+
+// roundTrip sends a line over the connection and returns the reply
+func roundTrip(t *testing.T, conn net.Conn, line string) string {
+	t.Helper()
+
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write([]byte(line + "\n")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	return reply
+}
+
+func TestProxyBalancesConnections(t *testing.T) {
+	backend1 := newEchoBackend(t, "backend1")
+	backend2 := newEchoBackend(t, "backend2")
+	_, addr := startProxy(t, DefaultConfig(), backend1, backend2)
+
+	seen := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		defer conn.Close()
+		seen[roundTrip(t, conn, "ping")] = true
+	}
+
+	for _, want := range []string{"backend1:ping\n", "backend2:ping\n"} {
+		if !seen[want] {
+			t.Errorf("Expected reply %q, got %v", want, seen)
+		}
+	}
+	if backend1.GetActiveConnections() != 1 || backend2.GetActiveConnections() != 1 {
+		t.Errorf("Active connections = %d, %d, want 1, 1",
+			backend1.GetActiveConnections(), backend2.GetActiveConnections())
+	}
+}
+
+func TestProxyFailsOverOnDialError(t *testing.T) {
+	// Reserve a port and close it so connections are refused
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	down := backend.New("down", "tcp://"+l.Addr().String(), 1)
+	l.Close()
+
+	up := newEchoBackend(t, "up")
+	_, addr := startProxy(t, DefaultConfig(), down, up)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	if got := roundTrip(t, conn, "ping"); got != "up:ping\n" {
+		t.Errorf("Reply = %q, want %q", got, "up:ping\n")
+	}
+	if got := down.GetCircuitBreaker().GetFailureCount(); got != 1 {
+		t.Errorf("Failure count = %d, want 1", got)
+	}
+}
+
+func TestProxyIdleTimeout(t *testing.T) {
+	_, addr := startProxy(t, Config{DialTimeout: time.Second, IdleTimeout: 50 * time.Millisecond},
+		newEchoBackend(t, "backend1"))
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	roundTrip(t, conn, "ping")
+
+	// The proxy closes the connection once it has been idle
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Read error = %v, want EOF", err)
+	}
+}
+
+func TestProxyShutdownDrains(t *testing.T) {
+	p, addr := startProxy(t, DefaultConfig(), newEchoBackend(t, "backend1"))
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	roundTrip(t, conn, "ping")
+
+	done := make(chan error, 1)
+	go func() { done <- p.Shutdown(context.Background()) }()
+
+	// Open connections keep working while draining
+	if got := roundTrip(t, conn, "again"); got != "backend1:again\n" {
+		t.Errorf("Reply = %q, want %q", got, "backend1:again\n")
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("Shutdown returned before the connection closed: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	conn.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Shutdown error = %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for shutdown")
+	}
+
+	// New connections are refused
+	if conn, err := net.Dial("tcp", addr); err == nil {
+		conn.Close()
+		t.Error("Expected dial to fail after shutdown")
+	}
+}
+
+func TestProxyShutdownTimeout(t *testing.T) {
+	p, addr := startProxy(t, DefaultConfig(), newEchoBackend(t, "backend1"))
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	roundTrip(t, conn, "ping")
+
+	// Connections still open after the drain timeout are closed
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := p.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Shutdown error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Read error = %v, want EOF", err)
+	}
+}
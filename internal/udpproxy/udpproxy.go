@@ -0,0 +1,369 @@
+package udpproxy
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"load-balancer/internal/backend"
+	"load-balancer/internal/balancer"
+	"load-balancer/internal/circuitbreaker"
+	"load-balancer/internal/metrics"
+	"load-balancer/internal/session"
+)
+
+const (
+	// DefaultIdleTimeout is the default time a flow may carry no datagrams
+	DefaultIdleTimeout = 30 * time.Second
+	// DefaultAffinityTTL is the default time a client stays bound to a backend
+	DefaultAffinityTTL = 10 * time.Minute
+
+	// maxDatagramSize is the largest UDP payload
+	maxDatagramSize = 64 * 1024
+)
+
+// ErrServerClosed is returned by Serve after the proxy has been closed
+var ErrServerClosed = errors.New("udpproxy: server closed")
+
+// Config holds the UDP proxy configuration
+type Config struct {
+	// IdleTimeout expires flows without datagrams in either direction
+	IdleTimeout time.Duration
+	// AffinityTTL keeps sending a client IP to the same backend after its
+	// flows expire; zero disables affinity
+	AffinityTTL time.Duration
+}
+
+// DefaultConfig returns the default UDP proxy configuration
+func DefaultConfig() Config {
+	return Config{
+		IdleTimeout: DefaultIdleTimeout,
+		AffinityTTL: DefaultAffinityTTL,
+	}
+}
+
+// flow is the association between a client address and a backend. Replies
+// from the backend are read on the flow's own socket and sent back to the
+// client through the listener.
+type flow struct {
+	client       net.Addr
+	backend      *backend.Backend
+	conn         net.Conn
+	lastActivity atomic.Int64
+	// permit records the backend's first reply, refusal or silence in its
+	// circuit breaker
+	permit *circuitbreaker.Permit
+}
+
+// touch records traffic on the flow
+func (f *flow) touch() {
+	f.lastActivity.Store(time.Now().UnixNano())
+}
+
+// idle returns how long the flow has carried no traffic
+func (f *flow) idle() time.Duration {
+	return time.Since(time.Unix(0, f.lastActivity.Load()))
+}
+
+// Proxy is a UDP load balancer that forwards datagrams to backends chosen by
+// a balancer. Backend URLs are used for their host and port only, e.g.
+// udp://10.0.0.1:53.
+type Proxy struct {
+	balancer balancer.Balancer
+	metrics  *metrics.Metrics
+	config   Config
+	affinity *session.Manager
+
+	mu     sync.Mutex
+	conn   net.PacketConn
+	flows  map[string]*flow
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// New creates a new UDP proxy
+func New(m *metrics.Metrics, config Config) *Proxy {
+	p := &Proxy{
+		metrics: m,
+		config:  config,
+		flows:   make(map[string]*flow),
+	}
+	if config.AffinityTTL > 0 {
+		p.affinity = session.NewManager(session.Config{
+			Enabled:         true,
+			Type:            session.IPBased,
+			TTL:             config.AffinityTTL,
+			CleanupInterval: config.AffinityTTL,
+		})
+	}
+	return p
+}
+
+// SetBalancer sets the load balancer
+func (p *Proxy) SetBalancer(b balancer.Balancer) {
+	p.balancer = b
+}
+
+// ListenAndServe listens on the UDP address and proxies received datagrams
+func (p *Proxy) ListenAndServe(addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	return p.Serve(conn)
+}
+
+// Serve forwards datagrams received on the connection until the proxy is
+// closed, then returns ErrServerClosed
+func (p *Proxy) Serve(conn net.PacketConn) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		conn.Close()
+		return ErrServerClosed
+	}
+	p.conn = conn
+	p.mu.Unlock()
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, client, err := conn.ReadFrom(buf)
+		if err != nil {
+			if p.isClosed() {
+				return ErrServerClosed
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+
+		f, err := p.flowFor(client)
+		if err != nil {
+			p.metrics.IncrementFailedRequests()
+			log.Printf("Failed to proxy datagram from %s: %v", client, err)
+			continue
+		}
+		f.touch()
+		if _, err := f.conn.Write(buf[:n]); err != nil {
+			log.Printf("Failed to forward datagram to backend %s: %v", f.backend.ID(), err)
+		}
+	}
+}
+
+// Close stops the listener and expires all flows
+func (p *Proxy) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	if p.conn != nil {
+		p.conn.Close()
+	}
+	for _, f := range p.flows {
+		f.conn.Close()
+	}
+	p.mu.Unlock()
+
+	p.wg.Wait()
+	if p.affinity != nil {
+		p.affinity.Stop()
+	}
+	return nil
+}
+
+func (p *Proxy) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
+
+// flowFor returns the client's flow, creating one on a newly chosen backend
+// if the client has none
+func (p *Proxy) flowFor(client net.Addr) (*flow, error) {
+	key := client.String()
+
+	p.mu.Lock()
+	f, ok := p.flows[key]
+	p.mu.Unlock()
+	if ok {
+		return f, nil
+	}
+
+	p.metrics.IncrementTotalRequests()
+	b, conn, permit, err := p.dial(client)
+	if err != nil {
+		return nil, err
+	}
+
+	f = &flow{client: client, backend: b, conn: conn, permit: permit}
+	f.touch()
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		conn.Close()
+		permit.Release()
+		return nil, ErrServerClosed
+	}
+	p.flows[key] = f
+	p.wg.Add(1)
+	p.mu.Unlock()
+
+	// Count the flow against the backend for its lifetime
+	b.IncrementConnections()
+	p.metrics.IncrementActiveConnections(b.ID())
+	p.bind(client, b)
+
+	go p.relayReplies(f)
+	return f, nil
+}
+
+// dial opens a socket to the client's affinity backend if it can still serve
+// the client, otherwise to the next backend from the balancer
+func (p *Proxy) dial(client net.Addr) (*backend.Backend, net.Conn, *circuitbreaker.Permit, error) {
+	routing := &balancer.Request{ClientAddr: client.String()}
+	if id := p.boundBackend(client); id != "" {
+		if b, err := p.balancer.GetBackend(id); err == nil && b.CanServeSession() {
+			if conn, permit, err := p.dialBackend(b); err == nil {
+				return b, conn, permit, nil
+			}
+			routing.Exclude(b.ID())
+		}
+	}
+
+	var lastErr error
+	for {
+		b, err := p.balancer.Next(routing)
+		if err != nil {
+			// Report the last backend failure rather than running out of backends
+			if lastErr != nil {
+				return nil, nil, nil, lastErr
+			}
+			return nil, nil, nil, err
+		}
+		routing.Exclude(b.ID())
+
+		conn, permit, err := p.dialBackend(b)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return b, conn, permit, nil
+	}
+}
+
+// dialBackend opens a connected UDP socket to a backend whose circuit breaker
+// admits a new flow
+func (p *Proxy) dialBackend(b *backend.Backend) (net.Conn, *circuitbreaker.Permit, error) {
+	permit, ok := b.GetCircuitBreaker().Acquire()
+	if !ok {
+		return nil, nil, fmt.Errorf("backend %s: circuit open", b.ID())
+	}
+	p.metrics.IncrementBackendRequests(b.ID())
+	conn, err := net.Dial("udp", b.URL().Host)
+	if err != nil {
+		permit.Record(false, 0)
+		p.metrics.IncrementBackendFailures(b.ID())
+		return nil, nil, fmt.Errorf("backend %s: %w", b.ID(), err)
+	}
+	return conn, permit, nil
+}
+
+// relayReplies sends datagrams from the backend back to the client until the
+// flow has been idle for the idle timeout. The first reply, or a refused
+// datagram (ICMP port unreachable), settles the flow's circuit breaker permit;
+// later replies are recorded without one. Many protocols, such as syslog,
+// never reply, so a flow that goes idle without being refused counts as a
+// success; otherwise a half-open circuit to such a backend would never close.
+func (p *Proxy) relayReplies(f *flow) {
+	defer p.expire(f)
+	replied := false
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		if p.config.IdleTimeout > 0 {
+			f.conn.SetReadDeadline(time.Now().Add(p.config.IdleTimeout))
+		}
+		n, err := f.conn.Read(buf)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				if f.idle() < p.config.IdleTimeout {
+					continue
+				}
+				if !replied {
+					f.permit.Record(true, 0)
+				}
+				return
+			}
+			if !p.isClosed() && !errors.Is(err, net.ErrClosed) {
+				if replied {
+					f.backend.GetCircuitBreaker().RecordFailure()
+				} else {
+					f.permit.Record(false, 0)
+				}
+				p.metrics.IncrementBackendFailures(f.backend.ID())
+			}
+			return
+		}
+		f.touch()
+		if replied {
+			f.backend.GetCircuitBreaker().RecordSuccess()
+		} else {
+			f.permit.Record(true, 0)
+			replied = true
+		}
+		if _, err := p.conn.WriteTo(buf[:n], f.client); err != nil {
+			log.Printf("Failed to send reply to %s: %v", f.client, err)
+		}
+	}
+}
+
+// expire removes a flow, keeping the client bound to its backend for the
+// affinity TTL
+func (p *Proxy) expire(f *flow) {
+	f.conn.Close()
+	// Flows closed before they were settled say nothing about the backend
+	f.permit.Release()
+
+	p.mu.Lock()
+	delete(p.flows, f.client.String())
+	p.mu.Unlock()
+
+	f.backend.DecrementConnections()
+	p.metrics.DecrementActiveConnections(f.backend.ID())
+	p.bind(f.client, f.backend)
+	p.wg.Done()
+}
+
+// bind records the client's backend for affinity
+func (p *Proxy) bind(client net.Addr, b *backend.Backend) {
+	if p.affinity != nil {
+		p.affinity.SetBackendIDForKey(clientIP(client), b.ID())
+	}
+}
+
+// boundBackend returns the ID of the client's affinity backend, if any
+func (p *Proxy) boundBackend(client net.Addr) string {
+	if p.affinity == nil {
+		return ""
+	}
+	return p.affinity.GetBackendIDForKey(clientIP(client))
+}
+
+// clientIP returns the IP of a client address, since clients often send from
+// a new source port for each exchange
+func clientIP(addr net.Addr) string {
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		return udpAddr.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
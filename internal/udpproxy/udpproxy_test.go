@@ -0,0 +1,221 @@
+package udpproxy
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"load-balancer/internal/backend"
+	"load-balancer/internal/balancer"
+	"load-balancer/internal/circuitbreaker"
+	"load-balancer/internal/metrics"
+)
+
+// newEchoBackend starts a UDP server that echoes datagrams prefixed with its ID
+func newEchoBackend(t *testing.T, id string) *backend.Backend {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(append([]byte(id+":"), buf[:n]...), addr)
+		}
+	}()
+
+	return backend.New(id, "udp://"+conn.LocalAddr().String(), 1)
+}
+
+// newSilentBackend starts a UDP server that reads datagrams without replying
+func newSilentBackend(t *testing.T, id string) *backend.Backend {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			if _, _, err := conn.ReadFrom(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	return backend.New(id, "udp://"+conn.LocalAddr().String(), 1)
+}
+
+// startProxy serves a UDP proxy over the backends on a local port
+func startProxy(t *testing.T, config Config, backends ...*backend.Backend) (*Proxy, string) {
+	t.Helper()
+
+	bal, err := balancer.New("round-robin")
+	if err != nil {
+		t.Fatalf("Failed to create balancer: %v", err)
+	}
+	for _, b := range backends {
+		bal.AddBackend(b.ID(), b)
+	}
+
+	p := New(metrics.New(), config)
+	p.SetBalancer(bal)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go p.Serve(conn)
+	t.Cleanup(func() { p.Close() })
+	return p, conn.LocalAddr().String()
+}
+
+// exchange sends a datagram from the connection and returns the reply
+func exchange(t *testing.T, conn net.Conn, msg string) string {
+	t.Helper()
+
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write([]byte(msg)); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	buf := make([]byte, 512)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	return string(buf[:n])
+}
+
+func TestProxyFlows(t *testing.T) {
+	backend1 := newEchoBackend(t, "backend1")
+	backend2 := newEchoBackend(t, "backend2")
+	_, addr := startProxy(t, Config{IdleTimeout: time.Minute}, backend1, backend2)
+
+	client1, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client1.Close()
+	client2, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client2.Close()
+
+	// Each client keeps its flow's backend and receives its replies
+	first := exchange(t, client1, "a")
+	second := exchange(t, client2, "b")
+	if first[:9] == second[:9] {
+		t.Errorf("Replies = %q, %q, want one per backend", first, second)
+	}
+	if got := exchange(t, client1, "c"); got[:9] != first[:9] {
+		t.Errorf("Reply = %q, want the flow's backend %q", got, first[:9])
+	}
+	if backend1.GetActiveConnections() != 1 || backend2.GetActiveConnections() != 1 {
+		t.Errorf("Active flows = %d, %d, want 1, 1",
+			backend1.GetActiveConnections(), backend2.GetActiveConnections())
+	}
+}
+
+func TestProxyExpiresIdleFlows(t *testing.T) {
+	b := newEchoBackend(t, "backend1")
+	_, addr := startProxy(t, Config{IdleTimeout: 50 * time.Millisecond}, b)
+
+	client, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client.Close()
+	exchange(t, client, "a")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for b.GetActiveConnections() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timeout waiting for idle flow to expire")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A new datagram opens a new flow
+	if got := exchange(t, client, "b"); got != "backend1:b" {
+		t.Errorf("Reply = %q, want %q", got, "backend1:b")
+	}
+}
+
+func TestProxyAffinity(t *testing.T) {
+	tests := []struct {
+		name        string
+		affinityTTL time.Duration
+		sameBackend bool
+	}{
+		{"with affinity", time.Minute, true},
+		{"without affinity", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, addr := startProxy(t, Config{IdleTimeout: time.Minute, AffinityTTL: tt.affinityTTL},
+				newEchoBackend(t, "backend1"), newEchoBackend(t, "backend2"))
+
+			// Clients on the same IP use new source ports for each exchange
+			var replies []string
+			for _, msg := range []string{"a", "b"} {
+				client, err := net.Dial("udp", addr)
+				if err != nil {
+					t.Fatalf("Dial failed: %v", err)
+				}
+				replies = append(replies, exchange(t, client, msg))
+				client.Close()
+			}
+
+			same := replies[0][:9] == replies[1][:9]
+			if same != tt.sameBackend {
+				t.Errorf("Replies = %v, same backend = %v, want %v", replies, same, tt.sameBackend)
+			}
+		})
+	}
+}
+
+func TestProxySilentBackendClosesCircuit(t *testing.T) {
+	b := newSilentBackend(t, "syslog1")
+	cb := b.GetCircuitBreaker()
+	cb.SetConfig(circuitbreaker.Config{FailureThreshold: 1, ResetTimeout: 10 * time.Millisecond, HalfOpenLimit: 2})
+	cb.RecordFailure()
+	if cb.GetState() != circuitbreaker.Open {
+		t.Fatalf("State = %v, want Open", cb.GetState())
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	_, addr := startProxy(t, Config{IdleTimeout: 50 * time.Millisecond}, b)
+
+	// Each one-way flow is a half-open probe that succeeds once it goes idle
+	// without being refused
+	for i := 0; i < 2; i++ {
+		client, err := net.Dial("udp", addr)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		defer client.Close()
+		if _, err := client.Write([]byte("<13>log line")); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for cb.GetState() != circuitbreaker.Closed {
+		if time.Now().After(deadline) {
+			t.Fatalf("State = %v after idle flows, want Closed", cb.GetState())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}